@@ -0,0 +1,90 @@
+package collage
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"os"
+)
+
+// CreateAnimation は各画像をタイルサイズに収めた1枚ずつのフレームとするアニメーションGIFを生成する
+// delayはフレームの表示時間（1/100秒単位）。cfg.NoUpscaleの場合、タイルより小さい画像は拡大せずフレームの中央に置く
+func CreateAnimation(imgList []image.Image, cfg Config, delay int) (*gif.GIF, error) {
+	if len(imgList) == 0 {
+		return nil, errors.New("no images to animate")
+	}
+	if cfg.TileSize < 1 {
+		return nil, fmt.Errorf("invalid tile size %d", cfg.TileSize)
+	}
+	if delay < 0 {
+		return nil, fmt.Errorf("invalid delay %d", delay)
+	}
+	interp, err := interpolation(cfg.Interp)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.validFlip(); err != nil {
+		return nil, err
+	}
+	if err := cfg.validFilter(); err != nil {
+		return nil, err
+	}
+
+	// GIFは半透明を扱えないため透明指定時は白で塗る
+	bg := cfg.Background
+	if bg.A == 0 {
+		bg = color.RGBA{255, 255, 255, 255}
+	}
+
+	tileW, tileH := cfg.tileDims(cfg.TileSize)
+	rect := image.Rect(0, 0, tileW, tileH)
+	anim := &gif.GIF{}
+	for k, img := range imgList {
+		// 背景の上に中央揃えで配置してからフルカラーを減色する
+		frame := image.NewRGBA(rect)
+		draw.Draw(frame, rect, &image.Uniform{bg}, image.Point{}, draw.Src)
+		// キャッシュのタイルは切り取り済み
+		img, _, cached := uncache(img)
+		if !cached {
+			img = cfg.crop(img)
+		}
+		h, v := cfg.flip(k)
+		resized := flipImage(fitTile(img, tileW, tileH, cfg.Fit, interp, cfg.NoUpscale), h, v)
+		if cfg.Brightness != 0 || cfg.Contrast != 0 {
+			adjusted := toRGBA(resized)
+			adjustRect(adjusted, adjusted.Rect, cfg.Brightness, cfg.Contrast)
+			resized = adjusted
+		}
+		rw, rh := resized.Bounds().Dx(), resized.Bounds().Dy()
+		offset := image.Pt((tileW-rw)/2, (tileH-rh)/2)
+		draw.Draw(frame, image.Rectangle{offset, offset.Add(image.Pt(rw, rh))}, resized, resized.Bounds().Min, draw.Over)
+
+		filterRect(frame, rect, cfg.Filter)
+		paletted := image.NewPaletted(rect, palette.Plan9)
+		draw.FloydSteinberg.Draw(paletted, rect, frame, image.Point{})
+		anim.Image = append(anim.Image, paletted)
+		anim.Delay = append(anim.Delay, delay)
+	}
+	return anim, nil
+}
+
+// SaveAnimation はアニメーションGIFをファイルに保存する（"-" の場合は標準出力）
+func SaveAnimation(filename string, anim *gif.GIF) error {
+	if filename == "-" {
+		return gif.EncodeAll(os.Stdout, anim)
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := gif.EncodeAll(f, anim); err != nil {
+		f.Close()
+		return err
+	}
+	// 書き込みエラーはCloseで初めて分かることがあるため無視しない
+	return f.Close()
+}
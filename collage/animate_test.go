@@ -0,0 +1,41 @@
+package collage
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestCreateAnimationNoUpscale(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	draw.Draw(src, src.Rect, &image.Uniform{color.RGBA{255, 0, 0, 255}}, image.Point{}, draw.Src)
+
+	for _, tt := range []struct {
+		noUpscale bool
+		want      int
+	}{
+		{false, 100 * 50},
+		{true, 20 * 10},
+	} {
+		cfg := DefaultConfig()
+		cfg.TileSize = 100
+		cfg.NoUpscale = tt.noUpscale
+		anim, err := CreateAnimation([]image.Image{src}, cfg, 50)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 背景（白）以外の画素が画像を描いた範囲
+		frame := anim.Image[0]
+		white := uint8(frame.Palette.Index(color.White))
+		drawn := 0
+		for _, p := range frame.Pix {
+			if p != white {
+				drawn++
+			}
+		}
+		if drawn != tt.want {
+			t.Errorf("NoUpscale %v: %d pixels drawn, want %d", tt.noUpscale, drawn, tt.want)
+		}
+	}
+}
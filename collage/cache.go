@@ -0,0 +1,116 @@
+package collage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// TileCache はタイルサイズにリサイズ済みの画像をディレクトリにPNGとして保存し、
+// 次回以降の実行で元画像のデコードを省略するためのキャッシュ
+// キーは (パス, 更新日時, タイルサイズ, 収め方, 補間方法, 拡大の有無, 読み込み時の最大サイズ)
+type TileCache struct {
+	dir    string
+	cfg    Config
+	interp string
+}
+
+// NewTileCache はdirをキャッシュディレクトリとするキャッシュを作る（dirがなければ作成）
+// cfgのタイルサイズ・収め方・補間方法・NoUpscaleがキーに含まれる
+func NewTileCache(dir string, cfg Config) (*TileCache, error) {
+	if _, err := interpolation(cfg.Interp); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &TileCache{dir: dir, cfg: cfg, interp: cfg.Interp}, nil
+}
+
+// key はキャッシュファイルのパスを返す（maxDimensionはLoadOptions.MaxDimension）
+func (c *TileCache) key(path string, mtime time.Time, maxDimension int) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	w, h := c.cfg.tileDims(c.cfg.TileSize)
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%dx%d|%s|%s|%t|%t|%t|%d", abs, mtime.UnixNano(), w, h, c.cfg.Fit, c.interp, c.cfg.Square, c.cfg.Trim, c.cfg.NoUpscale, maxDimension)))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".png")
+}
+
+// load はキャッシュがあればそれを読み込み、なければloadで元画像を読み込んで
+// タイルサイズにリサイズした結果をキャッシュに保存する
+// 返す画像は元画像の大きさを保持したcachedTileになる。maxDimensionはloadが元画像を縮小する最大サイズ
+func (c *TileCache) load(path string, maxDimension int, load func() (image.Image, ImageInfo, error)) (image.Image, ImageInfo, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, ImageInfo{Path: path, Name: filepath.Base(path)}, err
+	}
+	cachePath := c.key(path, st.ModTime(), maxDimension)
+
+	if img, err := readPNG(cachePath); err == nil {
+		info, err := statImageInfo(path)
+		return &cachedTile{img, image.Pt(info.Width, info.Height)}, info, err
+	}
+
+	img, info, err := load()
+	if err != nil {
+		return nil, info, err
+	}
+	interp, _ := interpolation(c.interp)
+	img = c.cfg.crop(img)
+	w, h := c.cfg.tileDims(c.cfg.TileSize)
+	tile := fitTile(img, w, h, c.cfg.Fit, interp, c.cfg.NoUpscale)
+	// キャッシュへの書き込みに失敗しても描画は続ける
+	_ = writePNG(cachePath, tile)
+	return &cachedTile{tile, image.Pt(info.Width, info.Height)}, info, nil
+}
+
+// cachedTile はキャッシュから読み込んだタイルと、元画像の大きさ
+// 配置結果のPlacement.Sourceにはタイルではなく元画像の大きさを報告する
+type cachedTile struct {
+	image.Image
+	source image.Point
+}
+
+// uncache はキャッシュのタイルなら中身のタイルと元画像の大きさを、そうでなければimgとその大きさを返す
+// cachedはキャッシュのタイルか（Trim・Squareで切り取り済みのため、もう一度切り取らない）
+func uncache(img image.Image) (tile image.Image, source image.Point, cached bool) {
+	if t, ok := img.(*cachedTile); ok {
+		return t.Image, t.source, true
+	}
+	return img, img.Bounds().Size(), false
+}
+
+// readPNG はPNGファイルを読み込む
+func readPNG(path string) (image.Image, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return png.Decode(bytes.NewReader(data))
+}
+
+// writePNG は一時ファイルに書き込んでからリネームし、途中までのファイルが残らないようにする
+func writePNG(path string, img image.Image) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tile-*")
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(tmp, img); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
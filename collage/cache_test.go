@@ -0,0 +1,30 @@
+package collage
+
+import (
+	"os"
+	"testing"
+)
+
+func TestTileCacheKeyMaxDimension(t *testing.T) {
+	dir := t.TempDir()
+	src := writeTestPNG(t, t.TempDir(), "a.png", true)
+	cfg := DefaultConfig()
+	cfg.TileSize = 16
+	cache, err := NewTileCache(dir, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 読み込み時の最大サイズが違えば別のタイルとして保存する
+	for _, maxDim := range []int{0, 8, 0, 8} {
+		if _, _, err := LoadImagesWithInfo([]string{src}, LoadOptions{Cache: cache, MaxDimension: maxDim}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("cache has %d tiles, want 2 (one per -max-dimension)", len(entries))
+	}
+}
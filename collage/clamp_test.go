@@ -0,0 +1,65 @@
+package collage
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/nfnt/resize"
+)
+
+// TestClampPremultipliedEdges は半透明のタイルをリサイズして合成したとき、
+// 透明部分との境界の画素が明るく・暗くにじまないことを確かめる
+// （合成結果は背景をタイルのアルファで覆った範囲 bg*(1-a) .. bg*(1-a)+255*a に収まる）
+func TestClampPremultipliedEdges(t *testing.T) {
+	bg := color.RGBA{128, 128, 128, 255}
+
+	// 透明な縁の内側に、白と黒の縞の半透明の正方形を描いたタイル
+	src := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for x := 16; x < 48; x++ {
+		c := color.NRGBA{255, 255, 255, 160}
+		if x%4 >= 2 {
+			c = color.NRGBA{0, 0, 0, 160}
+		}
+		draw.Draw(src, image.Rect(x, 16, x+1, 48), &image.Uniform{c}, image.Point{}, draw.Src)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		interp resize.InterpolationFunction
+		size   int
+	}{
+		{"lanczos3 down", resize.Lanczos3, 40},
+		{"lanczos3 up", resize.Lanczos3, 150},
+		{"bicubic up", resize.Bicubic, 150},
+	} {
+		tile := fitImage(src, tt.size, tt.size, "contain", tt.interp)
+		dst := image.NewRGBA(tile.Bounds())
+		draw.Draw(dst, dst.Rect, &image.Uniform{bg}, image.Point{}, draw.Src)
+		draw.Draw(dst, dst.Rect, tile, tile.Bounds().Min, draw.Over)
+
+		bad := 0
+		for y := dst.Rect.Min.Y; y < dst.Rect.Max.Y; y++ {
+			for x := dst.Rect.Min.X; x < dst.Rect.Max.X; x++ {
+				_, _, _, a := tile.At(x, y).RGBA()
+				lo := uint32(bg.R) * (0xffff - a) / 0xffff
+				hi := lo + 255*a/0xffff
+				c := dst.RGBAAt(x, y)
+				for _, v := range []uint8{c.R, c.G, c.B} {
+					// 整数演算の丸めの分は許容する
+					if uint32(v)+1 < lo || uint32(v) > hi+1 {
+						if bad == 0 {
+							t.Errorf("%s: pixel (%d,%d) = %v with tile alpha %d, want %d..%d", tt.name, x, y, c, a>>8, lo, hi)
+						}
+						bad++
+						break
+					}
+				}
+			}
+		}
+		if bad > 0 {
+			t.Errorf("%s: %d pixels out of range", tt.name, bad)
+		}
+	}
+}
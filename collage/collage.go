@@ -0,0 +1,600 @@
+// Package collage は画像一覧からアスペクト比を保ったタイル状のコラージュ画像を生成する
+package collage
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/nfnt/resize"
+	"golang.org/x/image/font"
+)
+
+// Config はコラージュの生成・保存に関する設定
+type Config struct {
+	// N は縦横の枚数 (N×N)。Rows / Cols が0より大きい場合はそちらを優先する
+	N    int
+	Rows int
+	Cols int
+
+	// Layout は配置方法（grid: Rows×Colsの均一なグリッド /
+	// pack: 縦横比に応じて横長は2列、縦長は2行のセルを割り当てCols列に詰める。行数は画像に合わせて決まる /
+	// justified: 縦横比を保ったまま高さTileSizeを目安に行へ詰め、行ごとに高さを調整して左右端を揃える。Heightは指定できない）
+	Layout string
+	// FillOrder はgridで画像をセルに割り当てる順序（row: 行ごとに左から右 / column: 列ごとに上から下、空はrow）
+	FillOrder string
+
+	// TileSize は各画像タイルの表示領域（ピクセル単位）
+	TileSize int
+	// TileAspect が0より大きい場合、タイルをその縦横比（幅/高さ、16:9なら16.0/9）にする
+	// 長辺をTileSizeとし、0の場合は正方形（grid レイアウトとアニメーションのみ）
+	TileAspect float64
+	// Width, Height が0より大きい場合はキャンバスをその大きさに固定し、
+	// TileSizeの代わりに余白を除いた領域に収まる最大のタイルサイズを使う（グリッドは中央に配置）
+	Width  int
+	Height int
+	// Margin は画像同士・外周の余白
+	Margin int
+	// TextHeight は各画像の下に確保するファイル名の描画領域の高さ（0の場合はフォントから自動計算）
+	TextHeight int
+	// Font はファイル名の描画に使うフォント（nilの場合はInconsolata）
+	Font font.Face
+	// FontFamily, FontStyle はFontのファミリー名とスタイル（regular / bold / italic / bold-italic）
+	// SVGではグリフを描かずにフォント名で指定するため、ラスター版と同じフォントを選ぶのに使う（空の場合はsans-serif / regular）
+	FontFamily string
+	FontStyle  string
+	// FallbackFont が設定されている場合、Fontにない文字（日本語など）はこのフォントで描画する
+	FallbackFont font.Face
+	// Fit はタイルへの収め方（contain: 全体を収めて余白を残す / cover: タイル全体を埋めてはみ出しを切り取る）
+	Fit string
+	// NoUpscale がtrueの場合、タイルより小さい画像は拡大せず元の大きさのままセルの中央に配置する
+	NoUpscale bool
+	// Flip はリサイズ後の各タイルの反転（none / horizontal: 左右 / vertical: 上下 / alternate: 1枚おきに左右、空はnone）
+	Flip string
+	// Filter は色の加工（none / grayscale: グレースケール / sepia: セピア調、空はnone）
+	Filter string
+	// FilterArea はFilterをかける範囲（tiles: 各タイルの画像だけでラベルなどの色は残す / all: 完成したキャンバス全体、空はall）
+	FilterArea string
+	// Brightness, Contrast はリサイズ後の各タイルの明るさ・コントラストの線形な調整量（-1〜1、0で変化なし）
+	Brightness float64
+	Contrast   float64
+	// Square がtrueの場合、リサイズ前に各画像を中央の最大の正方形に切り取る
+	// （coverと違い元画像の画素で切り取るため、正方形のタイルを最大の解像度で作れる）
+	Square bool
+	// Trim がtrueの場合、リサイズ前に各画像の四辺から左上隅の色とほぼ同じ色の余白を切り取る（Squareより先に行う）
+	Trim bool
+	// Captions はファイル名（ベース名）から表示するキャプションへの対応
+	// 対応がないファイルはLabelに従ってファイル名を表示する
+	Captions map[string]string
+	// LabelPos はファイル名ラベルの位置（below: 画像の下 / above: 画像の上 / overlay: 画像に重ねる）
+	// overlayの場合はテキスト領域を確保しない
+	LabelPos string
+	// Interp はリサイズ時の補間方法（nearest / bilinear / bicubic / lanczos2 / lanczos3）
+	Interp string
+	// Label はファイル名ラベルの表示方法（full: そのまま / noext: 拡張子なし / none: 表示しない）
+	Label string
+	// TextColor はファイル名・タイトルの文字色
+	TextColor color.RGBA
+	// LabelBackground が透明でなければ、ラベルの各行の背後に文字の幅と左右の余白の分だけこの色の矩形を敷く
+	// （overlayでは半透明の帯の代わりになる）
+	LabelBackground color.RGBA
+	// Number がtrueの場合、各画像の左上に配置順の番号（1から）を文字色のチップに重ねて描画する
+	Number bool
+	// Legend がtrueの場合、グリッドの下に配置順の番号とファイル名の一覧（"1: a.jpg   2: b.jpg ..."）を
+	// キャンバスの幅で折り返して描画する（番号はNumberのチップと対応する）
+	Legend bool
+	// Title が空でなければコラージュ上部にタイトルを中央揃えで描画する
+	Title string
+	// TitleFont はタイトルの描画に使うフォント（nilの場合は大きめのGoフォント）
+	TitleFont font.Face
+	// TitleStyle はTitleFontがnilの場合に使うGoフォントのスタイル（regular / bold / italic / bold-italic）
+	TitleStyle string
+	// TitleFallbackFont はタイトルでTitleFontのフォントにない文字を描画するフォント（nilの場合はFallbackFont）
+	TitleFallbackFont font.Face
+	// Hinting はTitleFontがnilの場合にタイトルのGoフォントに使うヒンティング（full / vertical / none、空はfull）
+	Hinting string
+	// BorderWidth が0より大きい場合は各画像の周囲に枠線を描画する
+	BorderWidth int
+	// Radius が0より大きい場合は各画像の角をその半径（ピクセル）で丸め、角の外側に背景を見せる
+	Radius int
+	// BorderColor は枠線の色
+	BorderColor color.RGBA
+	// GridColor のアルファが0でなければ、セル間の余白の中央にグリッド全体を貫く区切り線をその色で描画する
+	// （gridレイアウトのみ）
+	GridColor color.RGBA
+	// Shadow がtrueの場合は各画像の背後にぼかした影を描画する
+	// 背景が透明の場合はShadowOnTransparentもtrueの場合のみ描画する
+	Shadow              bool
+	ShadowOnTransparent bool
+	// Background は背景色（アルファが0の場合は塗りつぶさない）
+	Background color.RGBA
+	// BackgroundImage が設定されている場合、キャンバス全体を覆うよう拡大・切り取りして背景色の上に描画する
+	BackgroundImage image.Image
+
+	// OnPlace が設定されている場合、各画像をリサイズ・配置するたびにその結果を通知する（診断用）
+	OnPlace func(Placement)
+
+	// Watermark が設定されている場合、完成したキャンバスに透かしとして合成する
+	Watermark image.Image
+	// WatermarkPos は透かしの位置（center / tl / tr / bl / br）
+	WatermarkPos string
+	// WatermarkOpacity は透かしの不透明度 (0〜1)
+	WatermarkOpacity float64
+
+	// Format は出力形式（png / jpeg / webp / tiff）。空の場合はファイル名の拡張子から判定する
+	Format string
+	// Quality はJPEG/WebP出力時の画質 (1〜100)
+	Quality int
+	// TIFFCompression はTIFF出力時の圧縮方式（none / deflate）
+	TIFFCompression string
+	// PNGCompression はPNG出力時の圧縮レベル（default / speed / best / none）
+	PNGCompression string
+	// DPI が正なら、印刷時の解像度としてPNGのpHYsチャンクまたはJPEGのJFIFヘッダーに書き込む
+	// （画素は変わらない。TIFF / WebP には書き込まない）
+	DPI int
+	// Software が空でなければ、PNGのtEXtチャンク（Software）またはJPEGのコメントとして出力ファイルに埋め込む
+	Software string
+	// Sources が空でなければ、元画像のファイル名の一覧を改行区切りでPNGのiTXtチャンク（Description）
+	// またはJPEGのコメントとして埋め込む（TIFF / WebP にはメタデータを書き込まない）
+	Sources []string
+}
+
+// Placement は1枚の画像のリサイズ・配置の結果
+type Placement struct {
+	// Number は配置順の通し番号（1から。Config.Number で描画する番号と同じ）
+	Number int
+	// Index はCreateに渡した画像一覧での添字、Name は画像の名前（ラベルに使う前の名前）
+	Index int
+	Name  string
+	// Row, Col はセルの行・列（0から。複数のセルにまたがる場合は左上のセル）
+	Row int
+	Col int
+	// Source は元画像の幅・高さ（Trim・Squareで切り取る前、キャッシュのタイルでも元画像の大きさ）
+	Source image.Point
+	// Rect はキャンバス上の描画位置（大きさはリサイズ後の幅・高さ）
+	Rect image.Rectangle
+}
+
+// DefaultConfig はCLIのデフォルト値と同じ設定を返す
+func DefaultConfig() Config {
+	return Config{
+		N:                3,
+		Layout:           "grid",
+		TileSize:         300,
+		Margin:           10,
+		TextHeight:       0,
+		Fit:              "contain",
+		LabelPos:         "below",
+		Interp:           "lanczos3",
+		Label:            "full",
+		TextColor:        color.RGBA{0, 0, 0, 255},
+		BorderColor:      color.RGBA{0, 0, 0, 255},
+		Background:       color.RGBA{255, 255, 255, 255},
+		WatermarkPos:     "br",
+		WatermarkOpacity: 0.5,
+		Quality:          90,
+		TIFFCompression:  "deflate",
+		PNGCompression:   "default",
+	}
+}
+
+// Grid は設定から行数・列数を決定する
+func (c Config) Grid() (rows, cols int) {
+	rows, cols = c.N, c.N
+	if c.Rows > 0 {
+		rows = c.Rows
+	}
+	if c.Cols > 0 {
+		cols = c.Cols
+	}
+	return rows, cols
+}
+
+// GridFor はcount枚を収める正方形に近いグリッドを返す（列数はceil(sqrt(count))）
+func GridFor(count int) (rows, cols int) {
+	if count < 1 {
+		return 1, 1
+	}
+	cols = int(math.Ceil(math.Sqrt(float64(count))))
+	rows = (count + cols - 1) / cols
+	return rows, cols
+}
+
+// GridForAspect はcount枚を収めるグリッドのうち、キャンバスの縦横比（幅/高さ）がaspectに最も近い行数・列数を返す
+// キャンバスの大きさはcfgのタイルサイズ・余白・ラベル・タイトルからgridレイアウトと同じ式で見積もる。
+// 縦横比のずれ（比の対数の差）が同じなら空きセルの少ないグリッドを選ぶ
+func GridForAspect(count int, aspect float64, cfg Config) (rows, cols int) {
+	if count < 1 || aspect <= 0 {
+		return GridFor(count)
+	}
+	tileW, tileH := cfg.tileDims(cfg.TileSize)
+	band := cfg.textHeight(nil)
+	if cfg.LabelPos == "overlay" {
+		band = 0
+	}
+	header := 0
+	if cfg.Title != "" {
+		if face, err := cfg.titleFace(); err == nil {
+			header = face.Metrics().Height.Ceil() + cfg.Margin
+		}
+	}
+
+	bestDiff := math.Inf(1)
+	for c := 1; c <= count; c++ {
+		r := (count + c - 1) / c
+		w := c*tileW + (c+1)*cfg.Margin
+		h := header + r*(tileH+band) + (r+1)*cfg.Margin
+		diff := math.Abs(math.Log(float64(w) / float64(h) / aspect))
+		if diff < bestDiff-1e-9 || (diff < bestDiff+1e-9 && r*c < rows*cols) {
+			bestDiff, rows, cols = diff, r, c
+		}
+	}
+	return rows, cols
+}
+
+// ParseAspect は "16:9" 形式の縦横比を幅/高さの比に変換する
+func ParseAspect(s string) (float64, error) {
+	w, h, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, fmt.Errorf("invalid aspect ratio %q: expected W:H", s)
+	}
+	fw, err1 := strconv.ParseFloat(strings.TrimSpace(w), 64)
+	fh, err2 := strconv.ParseFloat(strings.TrimSpace(h), 64)
+	if err1 != nil || err2 != nil || fw <= 0 || fh <= 0 || math.IsInf(fw, 0) || math.IsInf(fh, 0) {
+		return 0, fmt.Errorf("invalid aspect ratio %q: expected positive W:H", s)
+	}
+	return fw / fh, nil
+}
+
+// tileDims は長辺をsizeとしたときのTileAspectに従うタイルの幅と高さを返す
+func (c Config) tileDims(size int) (w, h int) {
+	switch {
+	case c.TileAspect <= 0 || c.TileAspect == 1:
+		return size, size
+	case c.TileAspect > 1:
+		return size, max(1, int(math.Round(float64(size)/c.TileAspect)))
+	}
+	return max(1, int(math.Round(float64(size)*c.TileAspect))), size
+}
+
+// flip は配置順がk番目（0から）のタイルを左右・上下に反転するかを返す（alternateは奇数番目を左右に反転）
+func (c Config) flip(k int) (horizontal, vertical bool) {
+	switch c.Flip {
+	case "horizontal":
+		return true, false
+	case "vertical":
+		return false, true
+	case "alternate":
+		return k%2 == 1, false
+	}
+	return false, false
+}
+
+// validFlip はFlipが既知の反転方法か判定する
+func (c Config) validFlip() error {
+	switch c.Flip {
+	case "", "none", "horizontal", "vertical", "alternate":
+		return nil
+	}
+	return fmt.Errorf("unknown flip %q", c.Flip)
+}
+
+// validFilter はFilterとFilterAreaが既知の値で、Brightness・Contrastが範囲内か判定する
+func (c Config) validFilter() error {
+	switch c.Filter {
+	case "", "none", "grayscale", "sepia":
+	default:
+		return fmt.Errorf("unknown filter %q", c.Filter)
+	}
+	switch c.FilterArea {
+	case "", "tiles", "all":
+	default:
+		return fmt.Errorf("unknown filter area %q", c.FilterArea)
+	}
+	if c.Brightness < -1 || c.Brightness > 1 {
+		return fmt.Errorf("invalid brightness %v: must be between -1 and 1", c.Brightness)
+	}
+	if c.Contrast < -1 || c.Contrast > 1 {
+		return fmt.Errorf("invalid contrast %v: must be between -1 and 1", c.Contrast)
+	}
+	return nil
+}
+
+// filtered は色の加工をするか判定する
+func (c Config) filtered() bool {
+	return c.Filter != "" && c.Filter != "none"
+}
+
+// face は描画に使うフォントを返す
+func (c Config) face() font.Face {
+	face := textFont
+	if c.Font != nil {
+		face = c.Font
+	}
+	if c.FallbackFont != nil {
+		return &fallbackFace{primary: face, fallback: c.FallbackFont}
+	}
+	return face
+}
+
+// DefaultTitleSize はタイトルのデフォルトのフォントサイズ（ポイント）
+const DefaultTitleSize = 28
+
+// titleFace はタイトルの描画に使うフォントを返す（代替フォントがあればface()と同様に組み合わせる）
+func (c Config) titleFace() (font.Face, error) {
+	face := c.TitleFont
+	if face == nil {
+		hinting, err := ParseHinting(c.Hinting)
+		if err != nil {
+			return nil, err
+		}
+		if face, err = GoFontStyleFace(c.TitleStyle, DefaultTitleSize, hinting); err != nil {
+			return nil, err
+		}
+	}
+	fallback := c.TitleFallbackFont
+	if fallback == nil {
+		fallback = c.FallbackFont
+	}
+	if fallback != nil {
+		return &fallbackFace{primary: face, fallback: fallback}, nil
+	}
+	return face, nil
+}
+
+// label はタイルに表示するラベル（キャプションがあればそれを優先）を返す
+func (c Config) label(name string) string {
+	if c.Label == "none" {
+		return ""
+	}
+	if caption, ok := c.Captions[name]; ok {
+		return caption
+	}
+	return formatLabel(name, c.Label)
+}
+
+// textHeight はファイル名の描画領域の高さを返す（自動計算の場合は最も行数の多いラベルに合わせる）
+func (c Config) textHeight(labels []string) int {
+	if c.TextHeight > 0 {
+		return c.TextHeight
+	}
+	lines := 1
+	for _, l := range labels {
+		lines = max(lines, lineCount(l))
+	}
+	return textBandHeight(c.face(), lines)
+}
+
+// numberInset は番号チップを画像の左上からずらす量
+var numberInset = image.Pt(4, 4)
+
+// Create はアスペクト比維持でリサイズ・配置、文字描画を行いコラージュ画像を生成する
+func Create(imgList []image.Image, names []string, cfg Config) (image.Image, error) {
+	l, err := planLayout(imgList, names, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	outputImg := image.NewRGBA(image.Rect(0, 0, l.width, l.height))
+	l.render(outputImg, cfg)
+	return outputImg, nil
+}
+
+// render はキャンバスのうちdstの範囲を描画する（dstはキャンバス全体でも、その一部の帯でもよい）
+func (l *layout) render(dst *image.RGBA, cfg Config) {
+	area := dst.Bounds()
+
+	// 背景を塗りつぶし（透明指定時はゼロ値のまま）
+	if cfg.Background.A != 0 {
+		draw.Draw(dst, area, &image.Uniform{cfg.Background}, image.Point{}, draw.Src)
+	}
+	if l.background != nil {
+		draw.Draw(dst, area, l.background, l.background.Bounds().Min.Add(area.Min), draw.Over)
+	}
+
+	// タイトルを中央揃えで描画
+	if l.titleFace != nil {
+		titleWidth := font.MeasureString(l.titleFace, l.title).Ceil()
+		drawText(dst, l.titleFace, cfg.TextColor, (l.width-titleWidth)/2, l.titleY, l.title)
+	}
+
+	// グリッドの下に凡例を描画
+	lineHeight := l.face.Metrics().Height.Ceil()
+	for i, line := range l.legend {
+		drawText(dst, l.face, cfg.TextColor, l.legendAt.X, l.legendAt.Y+i*lineHeight, line)
+	}
+
+	drawShadows := cfg.Shadow && (cfg.Background.A != 0 || cfg.ShadowOnTransparent)
+
+	for k, t := range l.tiles {
+		if !tileExtent(t, cfg.Margin).Overlaps(area) {
+			continue
+		}
+		img := l.tileImage(k, cfg)
+		// 影は隣のセルにはみ出さないよう余白の半分までに収める
+		if drawShadows {
+			drawShadow(dst, img, t.rect, t.cell.Inset(-cfg.Margin/2))
+		}
+		draw.Draw(dst, t.rect, img, img.Bounds().Min, draw.Over)
+
+		// 画像の上に枠線と番号を描画
+		drawBorder(dst, t.rect, cfg.BorderWidth, cfg.Radius, cfg.BorderColor)
+		if cfg.Number {
+			drawNumberChip(dst, l.face, cfg.TextColor, t.rect.Min.Add(numberInset), t.number)
+		}
+
+		// ファイル名テキスト描画（タイル幅に収まるよう切り詰め）
+		if t.label == "" {
+			continue
+		}
+		if cfg.LabelPos == "overlay" {
+			drawOverlayLabel(dst, l.face, cfg.TextColor, cfg.LabelBackground, t.rect, l.textHeight, t.label)
+		} else {
+			drawLabel(dst, l.face, cfg.TextColor, cfg.LabelBackground, t.labelAt.X, t.labelAt.Y, t.box.Dx(), t.label)
+		}
+	}
+
+	// 画像の上から区切り線を描画
+	for _, r := range l.gridLines {
+		draw.Draw(dst, r, &image.Uniform{cfg.GridColor}, image.Point{}, draw.Over)
+	}
+
+	// 完成したキャンバスに透かしを合成
+	if l.watermark != nil {
+		drawWatermark(dst, l.watermark, l.watermarkRect, cfg.WatermarkOpacity)
+	}
+	if cfg.filtered() && cfg.FilterArea != "tiles" {
+		filterRect(dst, area, cfg.Filter)
+	}
+}
+
+// interpolation は補間方法名をresizeの補間関数に変換する
+func interpolation(name string) (resize.InterpolationFunction, error) {
+	switch name {
+	case "nearest":
+		return resize.NearestNeighbor, nil
+	case "bilinear":
+		return resize.Bilinear, nil
+	case "bicubic":
+		return resize.Bicubic, nil
+	case "lanczos2":
+		return resize.Lanczos2, nil
+	case "", "lanczos3":
+		return resize.Lanczos3, nil
+	}
+	return 0, fmt.Errorf("unknown interpolation %q", name)
+}
+
+// fitImage はアスペクト比を維持したままtw×thのタイルに合わせて画像をリサイズする
+// coverの場合はタイル全体を埋めるよう拡大し、はみ出した部分を中央基準で切り取る
+func fitImage(img image.Image, tw, th int, mode string, interp resize.InterpolationFunction) image.Image {
+	// オリジナル画像サイズ
+	ow := img.Bounds().Dx()
+	oh := img.Bounds().Dy()
+
+	// アスペクト比維持リサイズ計算
+	scaleW := float64(tw) / float64(ow)
+	scaleH := float64(th) / float64(oh)
+	scale := min(scaleW, scaleH)
+	if mode == "cover" {
+		scale = max(scaleW, scaleH)
+	}
+	newW := max(1, int(float64(ow)*scale))
+	newH := max(1, int(float64(oh)*scale))
+	if mode == "cover" {
+		// 丸め誤差でタイルより小さくならないようにする
+		newW, newH = max(newW, tw), max(newH, th)
+	} else {
+		newW, newH = min(newW, tw), min(newH, th)
+	}
+
+	// リサイズ処理（リサイズ済みのタイルなど、サイズが変わらない場合はそのまま使う）
+	resized := img
+	if newW != ow || newH != oh {
+		resized = clampPremultiplied(resize.Resize(uint(newW), uint(newH), img, interp))
+	}
+	if mode != "cover" {
+		return resized
+	}
+
+	// はみ出した部分を中央基準で切り取る
+	b := resized.Bounds()
+	x0 := b.Min.X + (b.Dx()-tw)/2
+	y0 := b.Min.Y + (b.Dy()-th)/2
+	return subImage(resized, image.Rect(x0, y0, x0+tw, y0+th))
+}
+
+// fitSize はfitTileが返す画像の幅と高さを、リサイズせずに求める
+func fitSize(b image.Rectangle, tw, th int, mode string, noUpscale bool) (int, int) {
+	ow, oh := b.Dx(), b.Dy()
+	upscale := ow <= tw && oh <= th
+	if mode == "cover" {
+		upscale = ow < tw || oh < th
+	}
+	if noUpscale && upscale {
+		return min(ow, tw), min(oh, th)
+	}
+	if mode == "cover" {
+		return tw, th
+	}
+	scale := min(float64(tw)/float64(ow), float64(th)/float64(oh))
+	return min(max(1, int(float64(ow)*scale)), tw), min(max(1, int(float64(oh)*scale)), th)
+}
+
+// clampPremultiplied はアルファ乗算済みの画像で色成分がアルファを超える画素をアルファに揃える
+// Lanczosなどの補間は透明部分との境界でオーバーシュートし、不正な値（色 > アルファ）を作る。
+// そのままdraw.Overで合成すると透過画像の縁が明るくにじむため、リサイズ直後に補正する
+func clampPremultiplied(img image.Image) image.Image {
+	switch m := img.(type) {
+	case *image.RGBA:
+		for i := 0; i+3 < len(m.Pix); i += 4 {
+			a := m.Pix[i+3]
+			m.Pix[i+0] = min(m.Pix[i+0], a)
+			m.Pix[i+1] = min(m.Pix[i+1], a)
+			m.Pix[i+2] = min(m.Pix[i+2], a)
+		}
+	case *image.RGBA64:
+		for i := 0; i+7 < len(m.Pix); i += 8 {
+			a := uint16(m.Pix[i+6])<<8 | uint16(m.Pix[i+7])
+			for c := 0; c < 6; c += 2 {
+				if v := uint16(m.Pix[i+c])<<8 | uint16(m.Pix[i+c+1]); v > a {
+					m.Pix[i+c], m.Pix[i+c+1] = uint8(a>>8), uint8(a)
+				}
+			}
+		}
+	}
+	return img
+}
+
+// fitTile はfitImageと同様に画像をタイルに合わせる。noUpscaleがtrueで拡大が必要な場合は
+// 鮮明さを保つためリサイズせず元の大きさのまま（coverではタイルからはみ出す部分だけ中央基準で切り取って）返す
+func fitTile(img image.Image, tw, th int, mode string, interp resize.InterpolationFunction, noUpscale bool) image.Image {
+	b := img.Bounds()
+	upscale := b.Dx() <= tw && b.Dy() <= th
+	if mode == "cover" {
+		upscale = b.Dx() < tw || b.Dy() < th
+	}
+	if !noUpscale || !upscale {
+		return fitImage(img, tw, th, mode, interp)
+	}
+	w, h := min(b.Dx(), tw), min(b.Dy(), th)
+	x0 := b.Min.X + (b.Dx()-w)/2
+	y0 := b.Min.Y + (b.Dy()-h)/2
+	return subImage(img, image.Rect(x0, y0, x0+w, y0+h))
+}
+
+// crop はTrimとSquareに従ってリサイズ前の元画像を切り取る
+func (c Config) crop(img image.Image) image.Image {
+	if c.Trim {
+		img = trimBorders(img)
+	}
+	if c.Square {
+		img = centerSquare(img)
+	}
+	return img
+}
+
+// centerSquare は画像の中央から切り取った最大の正方形を返す
+func centerSquare(img image.Image) image.Image {
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	x0 := b.Min.X + (b.Dx()-side)/2
+	y0 := b.Min.Y + (b.Dy()-side)/2
+	return subImage(img, image.Rect(x0, y0, x0+side, y0+side))
+}
+
+// subImage は画像の一部分を返す（SubImageを持たない画像はコピーして切り出す）
+func subImage(img image.Image, r image.Rectangle) image.Image {
+	if s, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return s.SubImage(r)
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, r.Min, draw.Src)
+	return dst
+}
@@ -0,0 +1,136 @@
+package collage
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nfnt/resize"
+)
+
+// ParseColor は "#rrggbb" / "#rrggbbaa" 形式または "transparent" を色に変換する
+func ParseColor(s string) (color.RGBA, error) {
+	if strings.EqualFold(s, "transparent") {
+		return color.RGBA{}, nil
+	}
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) != 6 && len(hex) != 8 {
+		return color.RGBA{}, fmt.Errorf("invalid color %q: expected #rrggbb, #rrggbbaa or transparent", s)
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color %q: %v", s, err)
+	}
+	// color.RGBAはアルファ乗算済みの値を保持する
+	a := uint32(v & 0xff)
+	return color.RGBA{
+		R: uint8(uint32(v>>24&0xff) * a / 0xff),
+		G: uint8(uint32(v>>16&0xff) * a / 0xff),
+		B: uint8(uint32(v>>8&0xff) * a / 0xff),
+		A: uint8(a),
+	}, nil
+}
+
+// luminance は色の輝度（ITU-R BT.601、0〜0xffff）を返す
+func luminance(c color.Color) uint32 {
+	r, g, b, _ := c.RGBA()
+	return (299*r + 587*g + 114*b) / 1000
+}
+
+// 色相順の並べ替えで無彩色とみなす彩度（最大値と最小値の差、0〜1）の上限
+const grayChroma = 0.08
+
+// averageColor は画像を8×8に縮小して透明部分を除いた平均色（r, g, b は0〜1）を求める
+func averageColor(img image.Image) (r, g, b float64) {
+	small := resize.Resize(8, 8, img, resize.Bilinear)
+	bounds := small.Bounds()
+	var sr, sg, sb, sa float64
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			// アルファ乗算済みの値を合計するため、透明な画素の色は自然に除かれる
+			pr, pg, pb, pa := small.At(x, y).RGBA()
+			sr, sg, sb, sa = sr+float64(pr), sg+float64(pg), sb+float64(pb), sa+float64(pa)
+		}
+	}
+	if sa == 0 {
+		return 0, 0, 0
+	}
+	return sr / sa, sg / sa, sb / sa
+}
+
+// AverageColor は各画像の平均色をさらに平均した不透明な色を返す（背景を画像の色調に合わせるため）
+// 各画像は縮小してから平均するため、大きな画像でも速い
+func AverageColor(imgList []image.Image) color.RGBA {
+	if len(imgList) == 0 {
+		return color.RGBA{A: 0xff}
+	}
+	var sr, sg, sb float64
+	for _, img := range imgList {
+		r, g, b := averageColor(img)
+		sr, sg, sb = sr+r, sg+g, sb+b
+	}
+	n := float64(len(imgList))
+	return color.RGBA{
+		R: uint8(math.Round(sr / n * 0xff)),
+		G: uint8(math.Round(sg / n * 0xff)),
+		B: uint8(math.Round(sb / n * 0xff)),
+		A: 0xff,
+	}
+}
+
+// hue はRGB（0〜1）の色相（0〜360度）と彩度の目安（最大値と最小値の差）を返す
+func hue(r, g, b float64) (h, chroma float64) {
+	hi, lo := max(r, g, b), min(r, g, b)
+	chroma = hi - lo
+	if chroma == 0 {
+		return 0, 0
+	}
+	switch hi {
+	case r:
+		h = math.Mod((g-b)/chroma, 6)
+	case g:
+		h = (b-r)/chroma + 2
+	default:
+		h = (r-g)/chroma + 4
+	}
+	h *= 60
+	if h < 0 {
+		h += 360
+	}
+	return h, chroma
+}
+
+// HueOrder は画像を平均色の色相順（赤→黄→緑→青→紫の虹色のグラデーション）に並べたときの添字を返す
+// 灰色に近い画像は色相が定まらないため、末尾に明るい順で並べる
+func HueOrder(imgList []image.Image) []int {
+	type key struct {
+		gray       bool
+		hue, light float64
+	}
+	keys := make([]key, len(imgList))
+	order := make([]int, len(imgList))
+	for i, img := range imgList {
+		r, g, b := averageColor(img)
+		h, chroma := hue(r, g, b)
+		keys[i] = key{gray: chroma < grayChroma, hue: h, light: (max(r, g, b) + min(r, g, b)) / 2}
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := keys[order[i]], keys[order[j]]
+		if a.gray != b.gray {
+			return !a.gray
+		}
+		if a.gray {
+			return a.light > b.light
+		}
+		return a.hue < b.hue
+	})
+	return order
+}
@@ -0,0 +1,113 @@
+package collage
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"io"
+	"math/bits"
+	"os"
+
+	"github.com/nfnt/resize"
+)
+
+// 知覚ハッシュのハミング距離がこの値以下なら同じ画像とみなす
+const phashThreshold = 4
+
+// Dedupe は重複する画像を取り除いたファイル一覧と、取り除いた数を返す（最初に現れたものを残す）
+// modeが "sha256" の場合はファイル内容が完全に一致するもの、"phash" の場合は
+// リサイズ・再圧縮されたコピーなど見た目がほぼ同じものを重複とみなす
+// onErrorが設定されていれば、phashでデコードできない画像はエラーにせず重複でないものとして残し、onErrorで通知する
+// （読み込めない画像の扱いは後の読み込みに任せる）
+func Dedupe(files []string, mode string, onError func(path string, err error)) ([]string, int, error) {
+	switch mode {
+	case "sha256":
+		return dedupeExact(files)
+	case "phash":
+		return dedupePerceptual(files, onError)
+	}
+	return nil, 0, fmt.Errorf("unknown dedupe mode %q", mode)
+}
+
+// dedupeExact はsha256が一致するファイルを取り除く
+func dedupeExact(files []string) ([]string, int, error) {
+	seen := make(map[[sha256.Size]byte]bool, len(files))
+	var unique []string
+	for _, path := range files {
+		sum, err := fileSHA256(path)
+		if err != nil {
+			return nil, 0, err
+		}
+		if seen[sum] {
+			continue
+		}
+		seen[sum] = true
+		unique = append(unique, path)
+	}
+	return unique, len(files) - len(unique), nil
+}
+
+// fileSHA256 はファイル内容のsha256を計算する
+func fileSHA256(path string) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	f, err := os.Open(path)
+	if err != nil {
+		return sum, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+// dedupePerceptual は知覚ハッシュが近いファイルを取り除く
+func dedupePerceptual(files []string, onError func(path string, err error)) ([]string, int, error) {
+	var (
+		unique []string
+		hashes []uint64
+	)
+	for _, path := range files {
+		img, err := LoadImage(path)
+		if err != nil && onError != nil {
+			onError(path, err)
+			unique = append(unique, path)
+			continue
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to load image %s: %w", path, err)
+		}
+		h := differenceHash(img)
+		dup := false
+		for _, u := range hashes {
+			if bits.OnesCount64(h^u) <= phashThreshold {
+				dup = true
+				break
+			}
+		}
+		if dup {
+			continue
+		}
+		hashes = append(hashes, h)
+		unique = append(unique, path)
+	}
+	return unique, len(files) - len(unique), nil
+}
+
+// differenceHash は画像を9×8のグレースケールに縮小し、隣り合う画素の明暗から64bitのハッシュを作る
+func differenceHash(img image.Image) uint64 {
+	small := resize.Resize(9, 8, img, resize.Bilinear)
+	b := small.Bounds()
+	var h uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if luminance(small.At(b.Min.X+x, b.Min.Y+y)) > luminance(small.At(b.Min.X+x+1, b.Min.Y+y)) {
+				h |= 1 << (y*8 + x)
+			}
+		}
+	}
+	return h
+}
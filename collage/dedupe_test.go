@@ -0,0 +1,40 @@
+package collage
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestDedupePerceptualUndecodable(t *testing.T) {
+	dir := t.TempDir()
+	a := writeTestPNG(t, dir, "a.png", true)
+	b := writeTestPNG(t, dir, "b.png", true)
+	flat := writeTestPNG(t, dir, "flat.png", false)
+	broken := filepath.Join(dir, "broken.png")
+	if err := os.WriteFile(broken, []byte("not an image"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	files := []string{a, broken, b, flat}
+
+	// 通知先がなければデコードできない画像でエラーになる
+	if _, _, err := Dedupe(files, "phash", nil); err == nil {
+		t.Error("Dedupe without onError succeeded with an undecodable file")
+	}
+
+	var reported []string
+	unique, removed, err := Dedupe(files, "phash", func(path string, err error) {
+		reported = append(reported, path)
+	})
+	if err != nil {
+		t.Fatalf("Dedupe: %v", err)
+	}
+	// デコードできない画像は重複とみなさず残し、同じ見た目のbだけを取り除く
+	if want := []string{a, broken, flat}; !slices.Equal(unique, want) || removed != 1 {
+		t.Errorf("Dedupe = %v, %d removed; want %v, 1 removed", unique, removed, want)
+	}
+	if want := []string{broken}; !slices.Equal(reported, want) {
+		t.Errorf("reported %v, want %v", reported, want)
+	}
+}
@@ -0,0 +1,148 @@
+package collage
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// drawBorder は矩形rの内側に幅widthの枠線を描画する（radiusが0より大きい場合は角を丸める）
+func drawBorder(dst draw.Image, r image.Rectangle, width, radius int, c color.Color) {
+	if width <= 0 || r.Empty() {
+		return
+	}
+	src := &image.Uniform{c}
+	if radius > 0 {
+		// 外側の角丸矩形から内側の角丸矩形を除いた部分を枠線にする
+		ring := roundedRectMask(r, radius)
+		inner := roundedRectMask(r.Inset(width), radius-width)
+		ib := inner.Bounds()
+		for y := ib.Min.Y; y < ib.Max.Y; y++ {
+			for x := ib.Min.X; x < ib.Max.X; x++ {
+				o := ring.PixOffset(x, y)
+				a := int(inner.Pix[inner.PixOffset(x, y)])
+				ring.Pix[o] = uint8(int(ring.Pix[o]) * (0xff - a) / 0xff)
+			}
+		}
+		draw.DrawMask(dst, r, src, image.Point{}, ring, r.Min, draw.Over)
+		return
+	}
+	edges := []image.Rectangle{
+		image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+width), // 上
+		image.Rect(r.Min.X, r.Max.Y-width, r.Max.X, r.Max.Y), // 下
+		image.Rect(r.Min.X, r.Min.Y, r.Min.X+width, r.Max.Y), // 左
+		image.Rect(r.Max.X-width, r.Min.Y, r.Max.X, r.Max.Y), // 右
+	}
+	for _, e := range edges {
+		draw.Draw(dst, e.Intersect(r), src, image.Point{}, draw.Over)
+	}
+}
+
+// flipImage は画像を左右（horizontal）・上下（vertical）に反転した画像を返す（どちらもfalseならそのまま）
+func flipImage(img image.Image, horizontal, vertical bool) image.Image {
+	if !horizontal && !vertical {
+		return img
+	}
+	b := img.Bounds()
+	src := image.NewRGBA(b)
+	draw.Draw(src, b, img, b.Min, draw.Src)
+	dst := image.NewRGBA(b)
+	w, h := b.Dx(), b.Dy()
+	for y := 0; y < h; y++ {
+		dy := y
+		if vertical {
+			dy = h - 1 - y
+		}
+		for x := 0; x < w; x++ {
+			dx := x
+			if horizontal {
+				dx = w - 1 - x
+			}
+			si := y*src.Stride + x*4
+			di := dy*dst.Stride + dx*4
+			copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+	return dst
+}
+
+// roundCorners は画像の四隅を半径radiusで丸め、角の外側を透明にした画像を返す
+func roundCorners(img image.Image, radius int) image.Image {
+	if radius <= 0 {
+		return img
+	}
+	b := img.Bounds()
+	dst := image.NewRGBA(b)
+	draw.DrawMask(dst, b, img, b.Min, roundedRectMask(b, radius), b.Min, draw.Src)
+	return dst
+}
+
+// roundedRectMask は矩形rを角の半径radiusで丸めた形状のアルファマスクを返す
+// 半径は短辺の半分までに収め、角の境界はアンチエイリアスする
+func roundedRectMask(r image.Rectangle, radius int) *image.Alpha {
+	mask := image.NewAlpha(r)
+	radius = min(radius, r.Dx()/2, r.Dy()/2)
+	if radius <= 0 {
+		draw.Draw(mask, r, image.Opaque, image.Point{}, draw.Src)
+		return mask
+	}
+	rad := float64(radius)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			// 最寄りの角の円の中心からの距離で被覆率を求める（角以外は不透明）
+			cx := min(max(float64(x)+0.5, float64(r.Min.X)+rad), float64(r.Max.X)-rad)
+			cy := min(max(float64(y)+0.5, float64(r.Min.Y)+rad), float64(r.Max.Y)-rad)
+			d := math.Hypot(float64(x)+0.5-cx, float64(y)+0.5-cy) - rad
+			cover := min(max(0.5-d, 0), 1)
+			mask.Pix[mask.PixOffset(x, y)] = uint8(cover*0xff + 0.5)
+		}
+	}
+	return mask
+}
+
+// 影の設定（オフセット・ぼかし半径・不透明度）
+const (
+	shadowOffset  = 4
+	shadowRadius  = 4
+	shadowOpacity = 0x80
+)
+
+// drawShadow は矩形rに配置される画像imgの背後に、その形状をぼかした影を描画する
+// （clipの外側には描画しない）
+func drawShadow(dst draw.Image, img image.Image, r, clip image.Rectangle) {
+	if r.Empty() {
+		return
+	}
+	// 画像のアルファをオフセットし、ぼかし半径分広げたマスクを作る
+	sr := r.Add(image.Pt(shadowOffset, shadowOffset))
+	mask := image.NewAlpha(sr.Inset(-shadowRadius))
+	draw.DrawMask(mask, sr, &image.Uniform{color.Alpha{shadowOpacity}}, image.Point{}, img, img.Bounds().Min, draw.Src)
+	boxBlur(mask, shadowRadius)
+
+	area := mask.Bounds().Intersect(clip)
+	draw.DrawMask(dst, area, &image.Uniform{color.Black}, image.Point{}, mask, area.Min, draw.Over)
+}
+
+// boxBlur はアルファマスクに半径rの水平・垂直ボックスブラーをかける
+func boxBlur(m *image.Alpha, r int) {
+	b := m.Bounds()
+	w, h := b.Dx(), b.Dy()
+	tmp := make([]uint8, len(m.Pix))
+	blur := func(src, dst []uint8, n, stride, step, count int) {
+		for line := 0; line < count; line++ {
+			base := line * stride
+			for i := 0; i < n; i++ {
+				sum, cnt := 0, 0
+				for k := max(0, i-r); k <= min(n-1, i+r); k++ {
+					sum += int(src[base+k*step])
+					cnt++
+				}
+				dst[base+i*step] = uint8(sum / cnt)
+			}
+		}
+	}
+	// 水平方向（各行）、垂直方向（各列）の順にぼかす
+	blur(m.Pix, tmp, w, m.Stride, 1, h)
+	blur(tmp, m.Pix, h, 1, m.Stride, w)
+}
@@ -0,0 +1,381 @@
+package collage
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SupportedExt は対応拡張子（大文字小文字は区別しない）
+var SupportedExt = []string{".jpg", ".jpeg", ".png", ".gif", ".bmp", ".tif", ".tiff"}
+
+// ScanOptions はディレクトリ走査の設定
+type ScanOptions struct {
+	// Recursive がfalseの場合はサブディレクトリを辿らない
+	Recursive bool
+	// SkipHidden がtrueの場合は "." で始まるファイルとディレクトリ（.git など）を辿らない
+	SkipHidden bool
+	// Limit が0より大きい場合、その数の画像が見つかった時点で走査を打ち切る
+	Limit int
+	// MinSize, MaxSize が0より大きい場合、ファイルサイズ（バイト）がその範囲外の画像を除外する
+	MinSize int64
+	MaxSize int64
+	// MinWidth, MinHeight が0より大きい場合、幅・高さ（ピクセル）がそれ未満の画像を除外する
+	// 寸法はヘッダーのみを読んで判定するため、画像全体はデコードしない
+	MinWidth  int
+	MinHeight int
+	// Exclude のいずれかのglobパターンにベース名が一致するファイルを除外する（例: "._*", "thumb_*"）
+	Exclude []string
+	// Context がキャンセルされるかデッドラインを過ぎた場合は走査を打ち切り、そのエラーを返す（nilの場合は打ち切らない）
+	Context context.Context
+}
+
+// ctxErr はContextが終了していればそのエラーを返す
+func (o ScanOptions) ctxErr() error {
+	if o.Context == nil {
+		return nil
+	}
+	return o.Context.Err()
+}
+
+// excluded はファイルのベース名がExcludeのいずれかのパターンに一致するか判定する
+func (o ScanOptions) excluded(path string) bool {
+	name := filepath.Base(path)
+	for _, pattern := range o.Exclude {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// filtered はファイル情報による絞り込みが設定されているか判定する
+func (o ScanOptions) filtered() bool {
+	return o.MinSize > 0 || o.MaxSize > 0 || o.MinWidth > 0 || o.MinHeight > 0
+}
+
+// accept はファイルが絞り込み条件を満たすか判定する
+// （サイズで除外できるものは寸法を調べる前に除外する）
+func (o ScanOptions) accept(path string, info fs.FileInfo) bool {
+	if o.MinSize > 0 && info.Size() < o.MinSize {
+		return false
+	}
+	if o.MaxSize > 0 && info.Size() > o.MaxSize {
+		return false
+	}
+	if o.MinWidth > 0 || o.MinHeight > 0 {
+		// 寸法を読み取れないファイルは後の読み込みでエラーにするため残す
+		img, err := statImageInfo(path)
+		if err != nil || img.Format == "" {
+			return true
+		}
+		if img.Width < o.MinWidth || img.Height < o.MinHeight {
+			return false
+		}
+	}
+	return true
+}
+
+// acceptEntry はディレクトリエントリが絞り込み条件を満たすか判定する
+// （条件がなければファイル情報を取得しない）
+func (o ScanOptions) acceptEntry(path string, d fs.DirEntry) bool {
+	if !o.filtered() {
+		return true
+	}
+	info, err := d.Info()
+	return err == nil && o.accept(path, info)
+}
+
+// DefaultScanOptions はCLIのデフォルト値と同じ走査設定を返す
+func DefaultScanOptions() ScanOptions {
+	return ScanOptions{Recursive: true, SkipHidden: true}
+}
+
+// GetImageFiles はディレクトリ内の画像ファイル一覧を取得
+func GetImageFiles(dir string, opts ScanOptions) ([]string, error) {
+	if !opts.Recursive {
+		return getTopLevelImageFiles(dir, opts)
+	}
+
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := opts.ctxErr(); err != nil {
+			return err
+		}
+		// 隠しディレクトリは中身ごと飛ばす（走査の起点は除く）
+		if opts.SkipHidden && path != dir && isHidden(d.Name()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() && IsImageFile(path) && !opts.excluded(path) && opts.acceptEntry(path, d) {
+			files = append(files, path)
+			if opts.Limit > 0 && len(files) >= opts.Limit {
+				return fs.SkipAll
+			}
+		}
+		return nil
+	})
+	return files, err
+}
+
+// getTopLevelImageFiles はディレクトリ直下の画像ファイル一覧のみを取得
+func getTopLevelImageFiles(dir string, opts ScanOptions) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		if err := opts.ctxErr(); err != nil {
+			return nil, err
+		}
+		if opts.SkipHidden && isHidden(e.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		if !e.IsDir() && IsImageFile(path) && !opts.excluded(path) && opts.acceptEntry(path, e) {
+			files = append(files, path)
+			if opts.Limit > 0 && len(files) >= opts.Limit {
+				break
+			}
+		}
+	}
+	return files, nil
+}
+
+// GlobImageFiles はパターンに一致する画像ファイル一覧を取得
+func GlobImageFiles(pattern string) ([]string, error) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, m := range matches {
+		if info, err := os.Stat(m); err == nil && !info.IsDir() && IsImageFile(m) {
+			files = append(files, m)
+		}
+	}
+	return files, nil
+}
+
+// FilterImageFiles はファイル一覧をoptsの除外パターンとサイズ・寸法の条件で絞り込む
+// （サイズ・寸法の条件がある場合、ファイル情報を取得できないものも除外）
+// GlobImageFiles や ReadImageList の結果に GetImageFiles と同じ条件を適用するために使う
+func FilterImageFiles(files []string, opts ScanOptions) []string {
+	if !opts.filtered() && len(opts.Exclude) == 0 {
+		return files
+	}
+	var kept []string
+	for _, f := range files {
+		if opts.excluded(f) {
+			continue
+		}
+		if !opts.filtered() {
+			kept = append(kept, f)
+		} else if info, err := os.Stat(f); err == nil && opts.accept(f, info) {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// ParseSize は "500", "200k", "1.5m" のようなバイト数を解析する（k/mは1024単位、大文字小文字は区別しない）
+func ParseSize(s string) (int64, error) {
+	v := strings.ToLower(strings.TrimSpace(s))
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(v, "k"):
+		mult, v = 1<<10, strings.TrimSuffix(v, "k")
+	case strings.HasSuffix(v, "m"):
+		mult, v = 1<<20, strings.TrimSuffix(v, "m")
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(mult)), nil
+}
+
+// ReadImageList は改行区切りのパス一覧を読み込み、対応拡張子のものだけを返す（空行は無視）
+func ReadImageList(r io.Reader) ([]string, error) {
+	var files []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		path := strings.TrimSpace(sc.Text())
+		if path != "" && IsImageFile(path) {
+			files = append(files, path)
+		}
+	}
+	return files, sc.Err()
+}
+
+// ReadImageListFile は1行に1つ画像のパスを書いたファイルを読み込み、書かれた順序のまま返す
+// ReadImageList と違い、存在しないファイルや対応していない形式のパスはエラーにする（空行は無視）
+// http:// / https:// で始まる行はURLとしてそのまま返す（LoadImagesWithInfoでダウンロードする）
+func ReadImageListFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var files []string
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		file := strings.TrimSpace(sc.Text())
+		if file == "" {
+			continue
+		}
+		// URLは拡張子がないこともあるため、形式は読み込み時に判定する
+		if IsURL(file) {
+			files = append(files, file)
+			continue
+		}
+		if !IsImageFile(file) {
+			return nil, fmt.Errorf("%s:%d: %s is not a supported image file", path, line, file)
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if info.IsDir() {
+			return nil, fmt.Errorf("%s:%d: %s is a directory", path, line, file)
+		}
+		files = append(files, file)
+	}
+	return files, sc.Err()
+}
+
+// isHidden はファイル名が "." で始まる隠しファイルか判定する
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// IsImageFile は対応拡張子か判定（大文字小文字は区別しないため .JPG も対象）
+func IsImageFile(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	for _, e := range SupportedExt {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// RandomSelect は与えられたスライスから乱数生成器rngを使ってランダムにn要素選ぶ
+// nがfilesの要素数より大きい場合は全要素を並べ替えて返す。
+// 共有の乱数源は使わないため、rand.New(rand.NewSource(seed))で作ったrngと同じ順序のfilesからは、
+// Goのバージョンや環境によらず同じ選択になる（math/randはシードを指定した乱数列を互換性のため変えない）
+func RandomSelect(rng *rand.Rand, files []string, n int) []string {
+	n = max(0, min(n, len(files)))
+	perm := rng.Perm(len(files))
+	selected := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		selected = append(selected, files[perm[i]])
+	}
+	return selected
+}
+
+// RepeatSelect はfilesから重複を許してn要素選ぶ（画像が少なくても大きなグリッドを埋めるため）
+// 全要素のランダムな並びを繰り返して使うため、各要素が選ばれる回数の差は1以内になる
+func RepeatSelect(rng *rand.Rand, files []string, n int) []string {
+	if len(files) == 0 {
+		return nil
+	}
+	selected := make([]string, 0, max(0, n))
+	for len(selected) < n {
+		selected = append(selected, RandomSelect(rng, files, n-len(selected))...)
+	}
+	return selected
+}
+
+// WeightedSelect はweightsに比例する確率で、重複なしにfilesからn要素選ぶ
+// （Efraimidis-Spirakis法: 各要素に u^(1/w) のキーを割り当てて上位n個を取る）
+func WeightedSelect(rng *rand.Rand, files []string, weights []float64, n int) ([]string, error) {
+	if len(weights) != len(files) {
+		return nil, errors.New("number of weights does not match number of files")
+	}
+	n = max(0, min(n, len(files)))
+	keys := make([]float64, len(files))
+	idx := make([]int, len(files))
+	for i, w := range weights {
+		if w <= 0 {
+			return nil, fmt.Errorf("weight for %s must be positive: %v", files[i], w)
+		}
+		keys[i] = math.Pow(rng.Float64(), 1/w)
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool { return keys[idx[a]] > keys[idx[b]] })
+
+	selected := make([]string, 0, n)
+	for _, i := range idx[:n] {
+		selected = append(selected, files[i])
+	}
+	return selected, nil
+}
+
+// RecencyWeights は更新日時が新しいファイルほど大きくなる重みを返す
+// 重みは最も古いファイルからの経過秒数 + 1（最も古いファイルも選ばれうるようにする）
+func RecencyWeights(files []string) ([]float64, error) {
+	mtimes := make([]time.Time, len(files))
+	var oldest time.Time
+	for i, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		mtimes[i] = info.ModTime()
+		if i == 0 || mtimes[i].Before(oldest) {
+			oldest = mtimes[i]
+		}
+	}
+	weights := make([]float64, len(files))
+	for i, t := range mtimes {
+		weights[i] = t.Sub(oldest).Seconds() + 1
+	}
+	return weights, nil
+}
+
+// SortFiles はmodeに従ってファイル一覧を並べ替える
+func SortFiles(files []string, mode string) error {
+	switch mode {
+	case "name":
+		sort.Strings(files)
+	case "mtime", "mtime-desc":
+		mtimes := make(map[string]time.Time, len(files))
+		for _, f := range files {
+			info, err := os.Stat(f)
+			if err != nil {
+				return err
+			}
+			mtimes[f] = info.ModTime()
+		}
+		sort.SliceStable(files, func(i, j int) bool {
+			if mode == "mtime-desc" {
+				return mtimes[files[i]].After(mtimes[files[j]])
+			}
+			return mtimes[files[i]].Before(mtimes[files[j]])
+		})
+	case "random", "none":
+		// 選択順をそのまま使う
+	default:
+		return fmt.Errorf("unknown sort mode %q", mode)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package collage
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestRandomSelect(t *testing.T) {
+	files := []string{"a.png", "b.png", "c.png", "d.png", "e.png"}
+	tests := []struct {
+		desc  string
+		files []string
+		n     int
+		want  int
+	}{
+		{"fewer than files", files, 3, 3},
+		{"as many as files", files, len(files), len(files)},
+		{"more than files", files, 10, len(files)},
+		{"negative", files, -1, 0},
+		{"no files", nil, 3, 0},
+		{"no files and zero", []string{}, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got := RandomSelect(rand.New(rand.NewSource(1)), tt.files, tt.n)
+			if len(got) != tt.want {
+				t.Fatalf("RandomSelect(%d of %d) returned %d files: %v", tt.n, len(tt.files), len(got), got)
+			}
+			// 重複なしに、filesに含まれる要素だけを選ぶ
+			sorted := slices.Clone(got)
+			slices.Sort(sorted)
+			if len(slices.Compact(sorted)) != len(got) {
+				t.Errorf("RandomSelect returned duplicates: %v", got)
+			}
+			for _, f := range got {
+				if !slices.Contains(tt.files, f) {
+					t.Errorf("RandomSelect returned %q, not in %v", f, tt.files)
+				}
+			}
+			// 同じシードなら同じ選択になる
+			if again := RandomSelect(rand.New(rand.NewSource(1)), tt.files, tt.n); !slices.Equal(again, got) {
+				t.Errorf("RandomSelect with the same seed = %v, want %v", again, got)
+			}
+		})
+	}
+}
+
+func TestRandomSelectDoesNotModifyFiles(t *testing.T) {
+	files := []string{"a.png", "b.png", "c.png"}
+	RandomSelect(rand.New(rand.NewSource(1)), files, len(files))
+	if want := []string{"a.png", "b.png", "c.png"}; !slices.Equal(files, want) {
+		t.Errorf("files = %v after RandomSelect, want %v", files, want)
+	}
+}
+
+// TestRandomSelectGolden はシードとソート済みの一覧から選ばれる画像が変わらないことを確かめる
+// （-seedで同じコラージュを再現できることの保証。math/randのシード付きの乱数列はGoのバージョンによらず同じ）
+func TestRandomSelectGolden(t *testing.T) {
+	files := []string{"a.png", "b.png", "c.png", "d.png", "e.png", "f.png", "g.png", "h.png"}
+	tests := []struct {
+		seed int64
+		want []string
+	}{
+		{1, []string{"f.png", "e.png", "c.png", "g.png"}},
+		{42, []string{"h.png", "f.png", "d.png", "e.png"}},
+	}
+	for _, tt := range tests {
+		if got := RandomSelect(rand.New(rand.NewSource(tt.seed)), files, 4); !slices.Equal(got, tt.want) {
+			t.Errorf("seed %d: RandomSelect = %q, want %q", tt.seed, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package collage
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// filterRect はdstのrの範囲の画素にfilter（grayscale / sepia）をかける（それ以外の名前では何もしない）
+// アルファ乗算済みの値にそのまま係数をかけるため、透明度は保たれる
+func filterRect(dst *image.RGBA, r image.Rectangle, filter string) {
+	var m [3][3]float64
+	switch filter {
+	case "grayscale":
+		// 輝度（ITU-R BT.601）
+		lum := [3]float64{0.299, 0.587, 0.114}
+		m = [3][3]float64{lum, lum, lum}
+	case "sepia":
+		// 一般的なセピア調の変換行列
+		m = [3][3]float64{
+			{0.393, 0.769, 0.189},
+			{0.349, 0.686, 0.168},
+			{0.272, 0.534, 0.131},
+		}
+	default:
+		return
+	}
+	r = r.Intersect(dst.Rect)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		row := dst.Pix[dst.PixOffset(r.Min.X, y):dst.PixOffset(r.Max.X, y)]
+		for i := 0; i < len(row); i += 4 {
+			cr, cg, cb, a := float64(row[i]), float64(row[i+1]), float64(row[i+2]), float64(row[i+3])
+			for c := range 3 {
+				// アルファ乗算済みの値はアルファを超えられない
+				v := m[c][0]*cr + m[c][1]*cg + m[c][2]*cb
+				row[i+c] = uint8(math.Round(min(v, a)))
+			}
+		}
+	}
+}
+
+// adjustRect はdstのrの範囲の画素の明るさとコントラストを線形に調整する（どちらも-1〜1、0で変化なし）
+// 色c（0〜1）を (c-0.5)×(1+contrast)+0.5+brightness とし、0〜1に収める。
+// アルファ乗算済みの値のまま計算するため、半透明の画素ではアルファに比例した調整になる
+func adjustRect(dst *image.RGBA, r image.Rectangle, brightness, contrast float64) {
+	if brightness == 0 && contrast == 0 {
+		return
+	}
+	r = r.Intersect(dst.Rect)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		row := dst.Pix[dst.PixOffset(r.Min.X, y):dst.PixOffset(r.Max.X, y)]
+		for i := 0; i < len(row); i += 4 {
+			a := float64(row[i+3])
+			for c := range 3 {
+				v := (float64(row[i+c])-a/2)*(1+contrast) + a/2 + brightness*a
+				row[i+c] = uint8(math.Round(max(0, min(v, a))))
+			}
+		}
+	}
+}
+
+// filterTile はリサイズ後のタイル画像の明るさ・コントラストを調整し、FilterAreaがtilesならFilterもかけたコピーを返す
+// （調整は色の加工より先に行う）
+func filterTile(img image.Image, cfg Config) image.Image {
+	filter := cfg.filtered() && cfg.FilterArea == "tiles"
+	if !filter && cfg.Brightness == 0 && cfg.Contrast == 0 {
+		return img
+	}
+	dst := toRGBA(img)
+	adjustRect(dst, dst.Rect, cfg.Brightness, cfg.Contrast)
+	if filter {
+		filterRect(dst, dst.Rect, cfg.Filter)
+	}
+	return dst
+}
+
+// toRGBA は画像を書き換え可能な*image.RGBAにコピーする
+func toRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(b)
+	draw.Draw(dst, b, img, b.Min, draw.Src)
+	return dst
+}
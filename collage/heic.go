@@ -0,0 +1,16 @@
+//go:build heic
+
+// HEIC/HEIFの読み込みはlibde265(LGPL)を含むデコーダに依存するため、
+// "heic" ビルドタグを指定した場合のみ有効にする
+//
+//	go build -tags heic
+package collage
+
+import (
+	// image.Decode に "heic" 形式を登録
+	_ "github.com/jdeng/goheif"
+)
+
+func init() {
+	SupportedExt = append(SupportedExt, ".heic", ".heif")
+}
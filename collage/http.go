@@ -0,0 +1,73 @@
+package collage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// maxDownloadSize はURLから読み込む画像の最大サイズ（誤って巨大なファイルを指定した場合に備える）
+const maxDownloadSize = 256 << 20
+
+// IsURL はpathがhttp://またはhttps://で始まるURLか判定する
+func IsURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// FetchImageInfo はURLから画像をダウンロードしてメモリ上でデコードし、メタデータとともに返す
+// Nameはパスの最後の要素（なければホスト名）、ModTimeはLast-Modifiedヘッダーの日時になる。ctxで打ち切れる
+func FetchImageInfo(ctx context.Context, rawURL string) (image.Image, ImageInfo, error) {
+	info := ImageInfo{Path: rawURL, Name: urlName(rawURL)}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, info, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, info, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, info, fmt.Errorf("unexpected HTTP status %s", resp.Status)
+	}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = t
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDownloadSize+1))
+	if err != nil {
+		return nil, info, err
+	}
+	if len(data) > maxDownloadSize {
+		return nil, info, fmt.Errorf("image exceeds %s", formatBytes(maxDownloadSize))
+	}
+	info.Size = int64(len(data))
+	img, format, taken, err := decodeImage(bytes.NewReader(data))
+	if err != nil {
+		return nil, info, err
+	}
+	info.Format, info.Taken = format, taken
+	info.Width, info.Height = img.Bounds().Dx(), img.Bounds().Dy()
+	return img, info, nil
+}
+
+// urlName はURLのパスの最後の要素をラベル用の名前として返す（パスが空ならホスト名）
+func urlName(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	if name := path.Base(u.Path); name != "/" && name != "." {
+		return name
+	}
+	return u.Host
+}
@@ -0,0 +1,521 @@
+package collage
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"sort"
+
+	"github.com/nfnt/resize"
+	"golang.org/x/image/font"
+)
+
+// minTileSize はキャンバスサイズから逆算したタイルサイズの下限
+const minTileSize = 16
+
+// layout はコラージュの配置（キャンバスの大きさと各タイルの位置）
+// ラスター画像とSVGの両方をここから描画する
+type layout struct {
+	width, height int
+	// background はキャンバスに合わせて切り取った背景画像（未指定の場合はnil）
+	background image.Image
+
+	// face はラベルのフォント、textHeight はラベル領域の高さ
+	face       font.Face
+	textHeight int
+	// titleFace が非nilの場合、titleY の位置に中央揃えでtitleを描画する
+	titleFace font.Face
+	title     string
+	titleY    int
+
+	// legend はグリッドの下に描画する凡例の各行、legendAt はその左上
+	legend   []string
+	legendAt image.Point
+
+	tiles []placedTile
+	// gridLines は画像の後に描画する区切り線（GridColorが指定された場合のみ）
+	gridLines []image.Rectangle
+	// watermark は縮小済みの透かし画像、watermarkRect はその配置位置（未指定の場合はnil）
+	watermark     image.Image
+	watermarkRect image.Rectangle
+
+	// interp はタイルのリサイズに使う補間関数
+	interp resize.InterpolationFunction
+}
+
+// placedTile は1枚の画像とその配置
+type placedTile struct {
+	// src は元画像、img はタイルに合わせてリサイズ（と角丸処理）した画像（tileImageで作る）、
+	// rect はその描画位置
+	src  image.Image
+	img  image.Image
+	rect image.Rectangle
+	// box は画像を納めるタイル領域、cell はラベルを含むセル全体
+	box  image.Rectangle
+	cell image.Rectangle
+	// label は表示するラベル（空の場合は描画しない）、labelAt はその左上（overlay以外）
+	label   string
+	labelAt image.Point
+	// number は配置順の通し番号（1から）
+	number int
+}
+
+// planLayout は設定を検証し、グリッドの各タイルの配置を計算する
+func planLayout(imgList []image.Image, names []string, cfg Config) (*layout, error) {
+	rows, cols := cfg.Grid()
+	if rows < 1 || cols < 1 {
+		return nil, fmt.Errorf("invalid grid %dx%d", rows, cols)
+	}
+	if cfg.TileSize < 1 && cfg.Width <= 0 && cfg.Height <= 0 {
+		return nil, fmt.Errorf("invalid tile size %d", cfg.TileSize)
+	}
+	if cfg.Width < 0 || cfg.Height < 0 {
+		return nil, fmt.Errorf("invalid canvas size %dx%d", cfg.Width, cfg.Height)
+	}
+	if cfg.BorderWidth < 0 {
+		return nil, fmt.Errorf("invalid border width %d", cfg.BorderWidth)
+	}
+	if cfg.Radius < 0 {
+		return nil, fmt.Errorf("invalid corner radius %d", cfg.Radius)
+	}
+	if cfg.Margin < 0 || cfg.TextHeight < 0 {
+		return nil, fmt.Errorf("margin and text height must be non-negative: %d, %d", cfg.Margin, cfg.TextHeight)
+	}
+	switch cfg.Fit {
+	case "contain", "cover":
+	default:
+		return nil, fmt.Errorf("unknown fit mode %q", cfg.Fit)
+	}
+	interp, err := interpolation(cfg.Interp)
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.Label {
+	case "full", "noext", "none":
+	default:
+		return nil, fmt.Errorf("unknown label mode %q", cfg.Label)
+	}
+	switch cfg.LabelPos {
+	case "below", "above", "overlay":
+	default:
+		return nil, fmt.Errorf("unknown label position %q", cfg.LabelPos)
+	}
+	if len(names) != len(imgList) {
+		return nil, errors.New("number of names does not match number of images")
+	}
+	if cfg.Legend && cfg.Height > 0 {
+		return nil, errors.New("legend is not supported with a fixed canvas height")
+	}
+
+	if err := cfg.validFlip(); err != nil {
+		return nil, err
+	}
+	if err := cfg.validFilter(); err != nil {
+		return nil, err
+	}
+	switch cfg.FillOrder {
+	case "", "row", "column":
+	default:
+		return nil, fmt.Errorf("unknown fill order %q", cfg.FillOrder)
+	}
+	switch cfg.Layout {
+	case "", "grid":
+		if len(imgList) > rows*cols {
+			return nil, fmt.Errorf("too many images for a %dx%d grid: %d", rows, cols, len(imgList))
+		}
+	case "pack", "justified":
+		if cfg.GridColor.A != 0 {
+			return nil, fmt.Errorf("grid lines are not supported with the %s layout", cfg.Layout)
+		}
+		if cfg.TileAspect > 0 && cfg.TileAspect != 1 {
+			return nil, fmt.Errorf("tile aspect ratios are not supported with the %s layout", cfg.Layout)
+		}
+		if cfg.FillOrder == "column" {
+			return nil, fmt.Errorf("column fill order is not supported with the %s layout", cfg.Layout)
+		}
+		if cfg.Layout == "justified" && cfg.Height > 0 {
+			return nil, errors.New("justified layout does not support a fixed canvas height")
+		}
+	default:
+		return nil, fmt.Errorf("unknown layout %q", cfg.Layout)
+	}
+
+	// キャッシュのタイルは中身を取り出し、元画像の大きさを控えておく
+	sources := make([]image.Point, len(imgList))
+	cached := make([]bool, len(imgList))
+	unwrapped := make([]image.Image, len(imgList))
+	for i, img := range imgList {
+		unwrapped[i], sources[i], cached[i] = uncache(img)
+	}
+	imgList = unwrapped
+
+	// Trim・Squareの場合はリサイズ前に元画像の余白や中央の正方形の外側を切り取る
+	// （キャッシュのタイルは保存前に切り取り済み）
+	if cfg.Trim || cfg.Square {
+		cropped := make([]image.Image, len(imgList))
+		for i, img := range imgList {
+			cropped[i] = img
+			if !cached[i] {
+				cropped[i] = cfg.crop(img)
+			}
+		}
+		imgList = cropped
+	}
+
+	// grid/packで各画像に割り当てるセル（列・行単位の矩形）と、配置する順序
+	var slots []image.Rectangle
+	var order []int
+	switch cfg.Layout {
+	case "pack":
+		slots, order, rows = packSlots(imgList, cols)
+	case "justified":
+	default:
+		slots = make([]image.Rectangle, len(imgList))
+		order = make([]int, len(imgList))
+		for i := range imgList {
+			row, col := i/cols, i%cols
+			if cfg.FillOrder == "column" {
+				row, col = i%rows, i/rows
+			}
+			slots[i] = image.Rect(col, row, col+1, row+1)
+			order[i] = i
+		}
+	}
+
+	tileSize := cfg.TileSize
+	margin := cfg.Margin
+	labels := make([]string, len(names))
+	for i, name := range names {
+		labels[i] = cfg.label(name)
+	}
+	l := &layout{face: cfg.face(), textHeight: cfg.textHeight(labels), interp: interp}
+
+	// セル内でラベル用に確保する高さ（overlayは画像に重ねるため確保しない）
+	band := l.textHeight
+	if cfg.LabelPos == "overlay" {
+		band = 0
+	}
+
+	// タイトル用のヘッダー領域（タイトルの高さ + 余白）
+	headerHeight := 0
+	if cfg.Title != "" {
+		if l.titleFace, err = cfg.titleFace(); err != nil {
+			return nil, fmt.Errorf("failed to load title font: %w", err)
+		}
+		headerHeight = l.titleFace.Metrics().Height.Ceil() + margin
+	}
+
+	// キャンバスサイズが指定されている場合は、そこに収まるタイルサイズ（長辺）を逆算する
+	if cfg.Width > 0 || cfg.Height > 0 {
+		aspect := cfg.TileAspect
+		if aspect <= 0 {
+			aspect = 1
+		}
+		tileSize = math.MaxInt
+		if cfg.Width > 0 {
+			tileW := (cfg.Width - (cols+1)*margin) / cols
+			tileSize = int(float64(tileW) / min(1, aspect))
+		}
+		if cfg.Height > 0 {
+			tileH := (cfg.Height-headerHeight-(rows+1)*margin)/rows - band
+			tileSize = min(tileSize, int(float64(tileH)*max(1, aspect)))
+		}
+		if w, h := cfg.tileDims(tileSize); min(w, h) < minTileSize {
+			return nil, fmt.Errorf("canvas %dx%d is too small for a %dx%d grid (tiles would be %dx%dpx, need at least %dpx)",
+				cfg.Width, cfg.Height, rows, cols, w, h, minTileSize)
+		}
+	}
+	tileW, tileH := cfg.tileDims(tileSize)
+
+	// 各画像のセル（ラベル領域を含む）を、外周の余白とタイトルを除いた領域の座標で求める
+	var cells []image.Rectangle
+	var content image.Point
+	if cfg.Layout == "justified" {
+		width := cols*tileSize + (cols-1)*margin
+		if cfg.Width > 0 {
+			width = cfg.Width - 2*margin
+		}
+		if width < minTileSize {
+			return nil, fmt.Errorf("canvas width %d is too small for a justified layout", cfg.Width)
+		}
+		order = make([]int, len(imgList))
+		for i := range order {
+			order[i] = i
+		}
+		cells, content = justifyRows(imgList, width, tileSize, margin, band)
+		// 行・列の位置を通知できるよう、同じ高さに並ぶセルを1行とみなして位置を割り当てる
+		slots = make([]image.Rectangle, len(cells))
+		row, col := 0, 0
+		for k, c := range cells {
+			if k > 0 && c.Min.Y != cells[k-1].Min.Y {
+				row, col = row+1, 0
+			}
+			slots[k] = image.Rect(col, row, col+1, row+1)
+			col++
+		}
+	} else {
+		cells = make([]image.Rectangle, len(slots))
+		for k, slot := range slots {
+			// 複数の列・行にまたがるセルは間の余白とラベル領域も含める
+			x := slot.Min.X * (tileW + margin)
+			y := slot.Min.Y * (tileH + band + margin)
+			w := slot.Dx()*tileW + (slot.Dx()-1)*margin
+			h := slot.Dy()*(tileH+band) + (slot.Dy()-1)*margin
+			cells[k] = image.Rect(x, y, x+w, y+h)
+		}
+		content = image.Pt(cols*tileW+(cols-1)*margin, rows*(tileH+band)+(rows-1)*margin)
+	}
+
+	gridWidth := content.X + 2*margin
+	gridHeight := headerHeight + content.Y + 2*margin
+	l.width, l.height = gridWidth, gridHeight
+	if cfg.Width > 0 {
+		l.width = cfg.Width
+	}
+
+	// 凡例は配置順の番号とファイル名をキャンバスの幅で折り返し、グリッドの下に追加した領域に描画する
+	if cfg.Legend {
+		entries := make([]string, len(order))
+		for k, i := range order {
+			entries[k] = fmt.Sprintf("%d: %s", k+1, names[i])
+		}
+		l.legend = wrapEntries(l.face, entries, l.width-2*margin)
+		l.height += len(l.legend)*l.face.Metrics().Height.Ceil() + margin
+	}
+	if cfg.Height > 0 {
+		l.height = cfg.Height
+	}
+	// グリッドをキャンバスの中央に配置するための原点（凡例がある場合は上に寄せる）
+	originX := (l.width - gridWidth) / 2
+	originY := (l.height - gridHeight) / 2
+	if cfg.Legend {
+		originY = 0
+		l.legendAt = image.Pt(margin, gridHeight)
+	}
+
+	if cfg.BackgroundImage != nil {
+		l.background = fitImage(cfg.BackgroundImage, l.width, l.height, "cover", interp)
+	}
+	if l.titleFace != nil {
+		l.title = truncateText(l.titleFace, cfg.Title, l.width-2*margin)
+		l.titleY = originY + margin
+	}
+
+	// 区切り線は各余白の中央に、グリッド全体を貫く1ピクセルの線として引く
+	if cfg.GridColor.A != 0 {
+		left, top := originX+margin/2, originY+headerHeight+margin/2
+		right, bottom := originX+gridWidth-margin+margin/2+1, originY+gridHeight-margin+margin/2+1
+		for c := 0; c <= cols; c++ {
+			x := left + c*(tileW+margin)
+			l.gridLines = append(l.gridLines, image.Rect(x, top, x+1, bottom))
+		}
+		for r := 0; r <= rows; r++ {
+			y := top + r*(tileH+band+margin)
+			l.gridLines = append(l.gridLines, image.Rect(left, y, right, y+1))
+		}
+	}
+
+	l.tiles = make([]placedTile, 0, len(order))
+	for k, i := range order {
+		// セルの位置と、画像を納めるタイル領域（ラベル領域を除いた部分）
+		cell := cells[k].Add(image.Pt(originX+margin, originY+headerHeight+margin))
+		box := image.Rect(cell.Min.X, cell.Min.Y, cell.Max.X, cell.Max.Y-band)
+		if cfg.LabelPos == "above" {
+			box = box.Add(image.Pt(0, band))
+		}
+		w, h := box.Dx(), box.Dy()
+
+		// タイルに合わせた大きさで中央に配置（リサイズは描画時に行う）
+		rw, rh := fitSize(imgList[i].Bounds(), w, h, cfg.Fit, cfg.NoUpscale)
+		offsetX := box.Min.X + (w-rw)/2
+		offsetY := box.Min.Y + (h-rh)/2
+
+		t := placedTile{
+			src:    imgList[i],
+			rect:   image.Rect(offsetX, offsetY, offsetX+rw, offsetY+rh),
+			box:    box,
+			cell:   cell,
+			label:  labels[i],
+			number: k + 1,
+		}
+		if cfg.OnPlace != nil {
+			cfg.OnPlace(Placement{
+				Number: t.number,
+				Index:  i,
+				Name:   names[i],
+				Row:    slots[k].Min.Y,
+				Col:    slots[k].Min.X,
+				Source: sources[i],
+				Rect:   t.rect,
+			})
+		}
+		switch cfg.LabelPos {
+		case "above":
+			t.labelAt = cell.Min
+		case "below":
+			t.labelAt = image.Pt(box.Min.X, box.Max.Y+5)
+		}
+		l.tiles = append(l.tiles, t)
+	}
+
+	if cfg.Watermark != nil {
+		canvas := image.Rect(0, 0, l.width, l.height)
+		if l.watermark, l.watermarkRect, err = placeWatermark(canvas, cfg.Watermark, cfg.WatermarkPos, cfg.WatermarkOpacity, margin); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// tileImage はk番目のタイルをリサイズした画像を返す（初回に作り、releaseTilesまで保持する）
+func (l *layout) tileImage(k int, cfg Config) image.Image {
+	t := &l.tiles[k]
+	if t.img == nil {
+		h, v := cfg.flip(k)
+		img := flipImage(filterTile(fitTile(t.src, t.box.Dx(), t.box.Dy(), cfg.Fit, l.interp, cfg.NoUpscale), cfg), h, v)
+		t.img = roundCorners(img, cfg.Radius)
+	}
+	return t.img
+}
+
+// tileExtent はタイルの描画が及ぶ範囲（影は余白の半分まで、ラベルは下端から最大1ピクセルはみ出す）
+func tileExtent(t placedTile, margin int) image.Rectangle {
+	return t.cell.Inset(-(margin/2 + 2))
+}
+
+// releaseTiles は描画範囲がyより上で終わるタイルのリサイズ済み画像を解放する
+func (l *layout) releaseTiles(y, margin int) {
+	for k := range l.tiles {
+		if tileExtent(l.tiles[k], margin).Max.Y <= y {
+			l.tiles[k].img = nil
+		}
+	}
+}
+
+// packAspect はpackレイアウトで横長・縦長のセルを割り当てる縦横比のしきい値
+// （1×1と2×1のセルで画像が占める割合が等しくなる√2を境にする）
+const packAspect = math.Sqrt2
+
+// packSlots は画像の縦横比に応じて横長は2×1、縦長は1×2、それ以外は1×1のセルを割り当て、
+// cols列のセル格子に上から順に空いている最初の位置へ詰める（シェルフ詰め）
+// 大きいセルを先に置き、残った隙間を1×1のセルで埋めるため、横長→縦長→その他の順に
+// 縦横比で並べ替えて配置する。割り当てたセルとその画像の添字、使った行数を返す
+func packSlots(imgList []image.Image, cols int) ([]image.Rectangle, []int, int) {
+	aspects := make([]float64, len(imgList))
+	shapes := make([]image.Point, len(imgList))
+	order := make([]int, len(imgList))
+	for i, img := range imgList {
+		b := img.Bounds()
+		aspects[i] = float64(b.Dx()) / float64(max(1, b.Dy()))
+		shapes[i] = image.Pt(1, 1)
+		switch {
+		case aspects[i] >= packAspect && cols >= 2:
+			shapes[i] = image.Pt(2, 1)
+		case aspects[i] <= 1/packAspect:
+			shapes[i] = image.Pt(1, 2)
+		}
+		order[i] = i
+	}
+	rank := func(i int) int {
+		switch shapes[i] {
+		case image.Pt(2, 1):
+			return 0
+		case image.Pt(1, 2):
+			return 1
+		}
+		return 2
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		i, j := order[a], order[b]
+		if rank(i) != rank(j) {
+			return rank(i) < rank(j)
+		}
+		return aspects[i] > aspects[j]
+	})
+
+	// 使用中のセル（行ごと、必要に応じて行を増やす）
+	var used [][]bool
+	free := func(x, y int, size image.Point) bool {
+		if x+size.X > cols {
+			return false
+		}
+		for dy := 0; dy < size.Y; dy++ {
+			for len(used) <= y+dy {
+				used = append(used, make([]bool, cols))
+			}
+			for dx := 0; dx < size.X; dx++ {
+				if used[y+dy][x+dx] {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	slots := make([]image.Rectangle, len(order))
+	rows := 0
+	for k, i := range order {
+		size := shapes[i]
+		for pos := 0; ; pos++ {
+			x, y := pos%cols, pos/cols
+			if !free(x, y, size) {
+				continue
+			}
+			slots[k] = image.Rectangle{Min: image.Pt(x, y), Max: image.Pt(x, y).Add(size)}
+			for dy := 0; dy < size.Y; dy++ {
+				for dx := 0; dx < size.X; dx++ {
+					used[y+dy][x+dx] = true
+				}
+			}
+			rows = max(rows, y+size.Y)
+			break
+		}
+	}
+	return slots, order, max(rows, 1)
+}
+
+// justifyRows は画像を縦横比を保ったまま行に詰め、各行の左右端が幅widthに揃うよう行の高さを調整する
+// （ジャスティファイドレイアウト）。高さtargetHeightで並べて幅を超えた時点で行を確定し、
+// 最後の行は引き伸ばさずtargetHeightのまま左寄せにする。各画像のセル（ラベル領域bandを含む）と全体の大きさを返す
+func justifyRows(imgList []image.Image, width, targetHeight, margin, band int) ([]image.Rectangle, image.Point) {
+	cells := make([]image.Rectangle, len(imgList))
+	aspect := func(i int) float64 {
+		b := imgList[i].Bounds()
+		return float64(b.Dx()) / float64(max(1, b.Dy()))
+	}
+
+	y := 0
+	for start := 0; start < len(imgList); {
+		// 目標の高さで幅を超えるまで画像を追加する
+		end, sum := start, 0.0
+		for end < len(imgList) {
+			sum += aspect(end)
+			end++
+			if sum*float64(targetHeight)+float64((end-start-1)*margin) >= float64(width) {
+				break
+			}
+		}
+		gaps := (end - start - 1) * margin
+		h := targetHeight
+		full := sum*float64(targetHeight)+float64(gaps) >= float64(width)
+		if full {
+			h = max(1, int(float64(width-gaps)/sum))
+		}
+
+		// 端数の誤差が右端に溜まらないよう、累積の縦横比から各画像の左端を決める
+		acc := 0.0
+		for i := start; i < end; i++ {
+			x0 := int(acc*float64(h)) + (i-start)*margin
+			acc += aspect(i)
+			x1 := int(acc*float64(h)) + (i-start)*margin
+			if full && i == end-1 {
+				x1 = width
+			}
+			cells[i] = image.Rect(x0, y, max(x0+1, x1), y+h+band)
+		}
+		y += h + band + margin
+		start = end
+	}
+	return cells, image.Pt(width, max(0, y-margin))
+}
@@ -0,0 +1,481 @@
+package collage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	// BMP, GIF, TIFFなど各種画像形式対応
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+
+	"github.com/nfnt/resize"
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// LoadOptions は画像読み込みの設定
+type LoadOptions struct {
+	// Workers は並列に読み込む数（1未満の場合は1）
+	Workers int
+	// OnError が設定されている場合、読み込みに失敗した画像はスキップしてOnErrorに通知する。
+	// nilの場合は最初のエラーで読み込みを打ち切る
+	OnError func(path string, err error)
+	// Placeholder がtrueの場合、読み込みに失敗した画像はスキップせずPlaceholderの代替画像に置き換える
+	// （OnErrorが設定されていれば失敗も通知する）
+	Placeholder bool
+	// OnProgress が設定されている場合、1枚読み込むごとに（失敗時も含め）進捗を通知する
+	OnProgress func(done, total int)
+	// Cache が設定されている場合、リサイズ済みのタイルをキャッシュから読み込む
+	// （キャッシュから読み込んだ画像はタイルサイズに収まっている）
+	Cache *TileCache
+	// MaxDimension が0より大きい場合、幅または高さがそれを超える画像は読み込み直後に縮小する
+	MaxDimension int
+	// Context がキャンセルされるかデッドラインを過ぎた場合は読み込みを打ち切り、そのエラーを返す
+	// 読み込み中のファイルは待たずに戻るため、応答しないネットワークマウントでも止まらない（nilの場合は打ち切らない）
+	Context context.Context
+	// MaxDownloads が0より大きい場合、URL（http:// / https://）の画像を同時にダウンロードする数をそれ以下に抑える
+	// （URLの画像はCacheを使わない）
+	MaxDownloads int
+}
+
+// done はContextの終了を通知するチャネルを返す（Contextがnilの場合は閉じられない）
+func (o LoadOptions) done() <-chan struct{} {
+	if o.Context == nil {
+		return nil
+	}
+	return o.Context.Done()
+}
+
+// ImageInfo は読み込んだ画像のメタデータ
+type ImageInfo struct {
+	// Path は読み込んだファイルのパス、Name はそのベース名
+	Path string
+	Name string
+	// Format はデコードに使われた形式名（jpeg / png など）
+	Format string
+	// Size はファイルサイズ、ModTime は更新日時
+	Size    int64
+	ModTime time.Time
+	// Taken はEXIFのDateTimeOriginal（取得できない場合はゼロ値）
+	Taken time.Time
+	// Width, Height は元画像の（EXIFの回転を適用した後の）ピクセル寸法
+	Width  int
+	Height int
+}
+
+// LoadImages は画像をopts.Workers並列で読み込む（リサイズは後で行うためここではそのまま）
+// 結果の順序はpathsの順序を保つ
+func LoadImages(paths []string, opts LoadOptions) ([]image.Image, []string, error) {
+	imgList, infos, err := LoadImagesWithInfo(paths, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = info.Name
+	}
+	return imgList, names, nil
+}
+
+// LoadImagesWithInfo はLoadImagesと同様に画像を読み込み、ファイル名の代わりにメタデータを返す
+func LoadImagesWithInfo(paths []string, opts LoadOptions) ([]image.Image, []ImageInfo, error) {
+	var downloads chan struct{}
+	if opts.MaxDownloads > 0 {
+		downloads = make(chan struct{}, opts.MaxDownloads)
+	}
+	return loadParallel(paths, opts, func(i int) (image.Image, ImageInfo, error) {
+		path := paths[i]
+		if IsURL(path) {
+			if downloads != nil {
+				select {
+				case downloads <- struct{}{}:
+					defer func() { <-downloads }()
+				case <-opts.done():
+					return nil, ImageInfo{Path: path, Name: urlName(path)}, opts.Context.Err()
+				}
+			}
+			img, info, err := FetchImageInfo(opts.Context, path)
+			if err != nil {
+				return nil, info, err
+			}
+			return limitDimension(img, opts.MaxDimension), info, nil
+		}
+		load := func() (image.Image, ImageInfo, error) {
+			img, info, err := LoadImageInfo(path)
+			if err != nil {
+				return nil, info, err
+			}
+			return limitDimension(img, opts.MaxDimension), info, nil
+		}
+		if opts.Cache != nil {
+			return opts.Cache.load(path, opts.MaxDimension, load)
+		}
+		return load()
+	})
+}
+
+// DecodeImages はファイルの代わりにio.Readerから画像をopts.Workers並列でデコードする
+// （ファイルシステムを使わずにテストやHTTPハンドラーからコラージュを作るため）
+// namesは各画像の名前で、ラベルとエラーの通知に使う。opts.Cacheは使わない。
+// 結果の順序はreadersの順序を保ち、OnErrorでスキップした画像は名前とともに除かれる
+func DecodeImages(readers []io.Reader, names []string, opts LoadOptions) ([]image.Image, []string, error) {
+	if len(names) != len(readers) {
+		return nil, nil, errors.New("number of names does not match number of readers")
+	}
+	imgList, infos, err := loadParallel(names, opts, func(i int) (image.Image, ImageInfo, error) {
+		info := ImageInfo{Path: names[i], Name: names[i]}
+		img, format, taken, err := decodeImage(readers[i])
+		if err != nil {
+			return nil, info, err
+		}
+		info.Format, info.Taken = format, taken
+		info.Width, info.Height = img.Bounds().Dx(), img.Bounds().Dy()
+		return limitDimension(img, opts.MaxDimension), info, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	loaded := make([]string, len(infos))
+	for i, info := range infos {
+		loaded[i] = info.Name
+	}
+	return imgList, loaded, nil
+}
+
+// CreateFromReaders はio.Readerから画像をデコードし、そのままコラージュ画像を生成する
+func CreateFromReaders(readers []io.Reader, names []string, opts LoadOptions, cfg Config) (image.Image, error) {
+	imgList, loaded, err := DecodeImages(readers, names, opts)
+	if err != nil {
+		return nil, err
+	}
+	return Create(imgList, loaded, cfg)
+}
+
+// loadParallel はkeysの各要素をloadでopts.Workers並列に読み込む（keysはエラーの通知に使う）
+// 結果の順序はkeysの順序を保つ
+func loadParallel(keys []string, opts LoadOptions, load func(i int) (image.Image, ImageInfo, error)) ([]image.Image, []ImageInfo, error) {
+	imgList := make([]image.Image, len(keys))
+	infos := make([]ImageInfo, len(keys))
+	failed := make([]bool, len(keys))
+
+	var (
+		wg      sync.WaitGroup
+		once    sync.Once
+		failKey string
+		failErr error
+	)
+	jobs := make(chan int)
+	done := make(chan struct{})
+
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	var (
+		mu       sync.Mutex
+		finished int
+	)
+	progress := func() {
+		if opts.OnProgress == nil {
+			return
+		}
+		mu.Lock()
+		finished++
+		opts.OnProgress(finished, len(keys))
+		mu.Unlock()
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				img, info, err := load(i)
+				progress()
+				if err != nil && opts.Placeholder {
+					if info.Name == "" {
+						info = ImageInfo{Path: keys[i], Name: filepath.Base(keys[i])}
+					}
+					img = Placeholder(info.Name)
+					if opts.OnError != nil {
+						mu.Lock()
+						opts.OnError(keys[i], err)
+						mu.Unlock()
+					}
+					err = nil
+				}
+				if err != nil && opts.OnError != nil {
+					failed[i] = true
+					mu.Lock()
+					opts.OnError(keys[i], err)
+					mu.Unlock()
+					continue
+				}
+				if err != nil {
+					// 最初のエラーで残りの読み込みを打ち切る
+					once.Do(func() {
+						failKey, failErr = keys[i], err
+						close(done)
+					})
+					continue
+				}
+				imgList[i] = img
+				infos[i] = info
+			}
+		}()
+	}
+
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+feed:
+	for i := range keys {
+		select {
+		case jobs <- i:
+		case <-done:
+			break feed
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+
+	// 読み込み中のワーカーが戻らなくてもContextの終了で打ち切る
+	finishedAll := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finishedAll)
+	}()
+	select {
+	case <-finishedAll:
+	case <-ctx.Done():
+		return nil, nil, fmt.Errorf("failed to load images: %w", ctx.Err())
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to load images: %w", err)
+	}
+
+	if failErr != nil {
+		return nil, nil, fmt.Errorf("failed to load image %s: %w", failKey, failErr)
+	}
+
+	// スキップした画像を詰める
+	loaded := imgList[:0]
+	loadedInfos := infos[:0]
+	for i := range keys {
+		if !failed[i] {
+			loaded = append(loaded, imgList[i])
+			loadedInfos = append(loadedInfos, infos[i])
+		}
+	}
+	return loaded, loadedInfos, nil
+}
+
+// ContactSheetLabel はコンタクトシート用に、ファイル名と寸法・ファイルサイズを2行にまとめたラベルを返す
+func ContactSheetLabel(info ImageInfo) string {
+	return fmt.Sprintf("%s\n%dx%d %s", info.Name, info.Width, info.Height, formatBytes(info.Size))
+}
+
+// formatBytes はバイト数を "1.2MB" のような読みやすい形式にする
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// ShuffleImages は画像と名前（infosがnilでなければメタデータも）の組を対応を保ったままrngでシャッフルする
+func ShuffleImages(rng *rand.Rand, imgList []image.Image, names []string, infos []ImageInfo) {
+	rng.Shuffle(len(imgList), func(i, j int) {
+		imgList[i], imgList[j] = imgList[j], imgList[i]
+		names[i], names[j] = names[j], names[i]
+		if infos != nil {
+			infos[i], infos[j] = infos[j], infos[i]
+		}
+	})
+}
+
+// LoadImage はファイルから画像を読み込む（JPEGはEXIFのOrientationに従って回転）
+func LoadImage(path string) (image.Image, error) {
+	img, _, err := LoadImageInfo(path)
+	return img, err
+}
+
+// statImageInfo はデコードせずにファイルのメタデータだけを取得する
+// （形式はヘッダーから判定し、JPEGの撮影日時はEXIFから読む）
+func statImageInfo(path string) (ImageInfo, error) {
+	info := ImageInfo{Path: path, Name: filepath.Base(path)}
+	f, err := os.Open(path)
+	if err != nil {
+		return info, err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return info, err
+	}
+	info.Size = st.Size()
+	info.ModTime = st.ModTime()
+	if c, format, err := image.DecodeConfig(f); err == nil {
+		info.Format = format
+		info.Width, info.Height = c.Width, c.Height
+	}
+	if info.Format == "jpeg" {
+		if _, err := f.Seek(0, io.SeekStart); err == nil {
+			var orientation int
+			orientation, info.Taken = readExif(f)
+			// 5〜8は90度回転を伴うため縦横を入れ替える
+			if orientation >= 5 {
+				info.Width, info.Height = info.Height, info.Width
+			}
+		}
+	}
+	return info, nil
+}
+
+// LoadImageInfo はファイルから画像を読み込み、メタデータとともに返す
+func LoadImageInfo(path string) (image.Image, ImageInfo, error) {
+	info := ImageInfo{Path: path, Name: filepath.Base(path)}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, info, err
+	}
+	defer f.Close()
+
+	if st, err := f.Stat(); err == nil {
+		info.Size = st.Size()
+		info.ModTime = st.ModTime()
+	}
+
+	img, format, taken, err := decodeImage(f)
+	if err != nil {
+		return nil, info, err
+	}
+	info.Format, info.Taken = format, taken
+	info.Width, info.Height = img.Bounds().Dx(), img.Bounds().Dy()
+	return img, info, nil
+}
+
+// decodeImage はrから画像をデコードし、JPEGの場合はEXIFのOrientationに従って回転する
+// EXIFを読み直すため、rがio.Seekerでない場合は全体をメモリに読み込む
+func decodeImage(r io.Reader) (img image.Image, format string, taken time.Time, err error) {
+	rs, ok := r.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, "", taken, err
+		}
+		rs = bytes.NewReader(data)
+	}
+	img, format, err = image.Decode(rs)
+	if err != nil {
+		return nil, "", taken, err
+	}
+	if format == "jpeg" {
+		if _, err := rs.Seek(0, io.SeekStart); err == nil {
+			var orientation int
+			orientation, taken = readExif(rs)
+			img = applyOrientation(img, orientation)
+		}
+	}
+	return img, format, taken, nil
+}
+
+// limitDimension は幅・高さがmaxDimに収まるようアスペクト比を維持して縮小する
+// 最終的なタイルはさらに小さいため、ここでは高速なバイリニア補間で十分
+func limitDimension(img image.Image, maxDim int) image.Image {
+	b := img.Bounds()
+	if maxDim <= 0 || (b.Dx() <= maxDim && b.Dy() <= maxDim) {
+		return img
+	}
+	return resize.Thumbnail(uint(maxDim), uint(maxDim), img, resize.Bilinear)
+}
+
+// readExif はEXIFのOrientationタグ（取得できない場合は1）と
+// DateTimeOriginalタグ（取得できない場合はゼロ値）を読み取る
+func readExif(r io.Reader) (orientation int, taken time.Time) {
+	orientation = 1
+	x, err := exif.Decode(r)
+	if err != nil {
+		return orientation, taken
+	}
+	if tag, err := x.Get(exif.Orientation); err == nil {
+		if o, err := tag.Int(0); err == nil && o >= 1 && o <= 8 {
+			orientation = o
+		}
+	}
+	if tag, err := x.Get(exif.DateTimeOriginal); err == nil {
+		if v, err := tag.StringVal(); err == nil {
+			if t, err := time.ParseInLocation("2006:01:02 15:04:05", strings.TrimSpace(v), time.Local); err == nil {
+				taken = t
+			}
+		}
+	}
+	return orientation, taken
+}
+
+// applyOrientation はEXIFのOrientation値(1〜8)に応じて回転・反転した画像を返す
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	// 5〜8は90度回転を伴うため縦横が入れ替わる
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // 左右反転
+				dx, dy = w-1-x, y
+			case 3: // 180度回転
+				dx, dy = w-1-x, h-1-y
+			case 4: // 上下反転
+				dx, dy = x, h-1-y
+			case 5: // 左上-右下の対角線で反転
+				dx, dy = y, x
+			case 6: // 時計回りに90度回転
+				dx, dy = h-1-y, x
+			case 7: // 右上-左下の対角線で反転
+				dx, dy = h-1-y, w-1-x
+			case 8: // 反時計回りに90度回転
+				dx, dy = y, w-1-x
+			}
+			si := src.PixOffset(x, y)
+			di := dst.PixOffset(dx, dy)
+			copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+	return dst
+}
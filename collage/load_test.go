@@ -0,0 +1,171 @@
+package collage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// encodedImages はPNGとJPEGにエンコードしたテスト画像を返す（ファイルシステムを使わずに読み込むため）
+func encodedImages(t testing.TB) (pngData, jpegData []byte) {
+	t.Helper()
+	imgList, _ := testImages(2)
+	var p, j bytes.Buffer
+	if err := png.Encode(&p, imgList[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(&j, imgList[1], nil); err != nil {
+		t.Fatal(err)
+	}
+	return p.Bytes(), j.Bytes()
+}
+
+func TestDecodeImages(t *testing.T) {
+	pngData, jpegData := encodedImages(t)
+	readers := []io.Reader{bytes.NewReader(pngData), bytes.NewReader(jpegData)}
+	imgList, names, err := DecodeImages(readers, []string{"a.png", "b.jpg"}, LoadOptions{Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a.png", "b.jpg"}; !slices.Equal(names, want) {
+		t.Errorf("names = %v, want %v", names, want)
+	}
+	if len(imgList) != 2 || imgList[0].Bounds().Size() != image.Pt(120, 90) || imgList[1].Bounds().Size() != image.Pt(160, 140) {
+		t.Errorf("decoded %d images, want 120x90 and 160x140", len(imgList))
+	}
+
+	if _, _, err := DecodeImages(readers, []string{"a.png"}, LoadOptions{}); err == nil {
+		t.Error("DecodeImages with mismatched names succeeded")
+	}
+}
+
+func TestDecodeImagesBadReader(t *testing.T) {
+	pngData, _ := encodedImages(t)
+	readers := func() []io.Reader {
+		return []io.Reader{bytes.NewReader(pngData), strings.NewReader("not an image")}
+	}
+	names := []string{"a.png", "bad.png"}
+
+	// OnErrorがなければ最初のエラーで失敗し、エラーに名前が含まれる
+	if _, _, err := DecodeImages(readers(), names, LoadOptions{}); err == nil || !strings.Contains(err.Error(), "bad.png") {
+		t.Errorf("DecodeImages error = %v, want an error naming bad.png", err)
+	}
+
+	// OnErrorがあればデコードできない画像を名前とともに除く
+	var failed []string
+	imgList, loaded, err := DecodeImages(readers(), names, LoadOptions{OnError: func(name string, err error) {
+		failed = append(failed, name)
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imgList) != 1 || !slices.Equal(loaded, []string{"a.png"}) || !slices.Equal(failed, []string{"bad.png"}) {
+		t.Errorf("DecodeImages = %d images %v, failed %v; want a.png loaded, bad.png failed", len(imgList), loaded, failed)
+	}
+}
+
+func TestCreateFromReaders(t *testing.T) {
+	pngData, jpegData := encodedImages(t)
+	cfg := DefaultConfig()
+	cfg.N = 2
+	cfg.TileSize = 50
+	cfg.Label = "none"
+	var placed int
+	cfg.OnPlace = func(Placement) { placed++ }
+	img, err := CreateFromReaders([]io.Reader{bytes.NewReader(pngData), bytes.NewReader(jpegData)},
+		[]string{"a.png", "b.jpg"}, LoadOptions{Workers: 2}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if placed != 2 {
+		t.Errorf("placed %d tiles, want 2", placed)
+	}
+	// 2×2のグリッドにタイル50px、余白10pxずつ（各行にはテキスト領域も確保される）
+	if got, want := img.Bounds().Size(), image.Pt(2*50+3*10, 2*(50+cfg.textHeight(nil))+3*10); got != want {
+		t.Errorf("canvas size = %v, want %v", got, want)
+	}
+
+	if _, err := CreateFromReaders([]io.Reader{strings.NewReader("junk")}, []string{"junk.png"}, LoadOptions{}, cfg); err == nil {
+		t.Error("CreateFromReaders with a bad reader succeeded")
+	}
+}
+
+func TestLoadParallelOrder(t *testing.T) {
+	keys := make([]string, 32)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("img%02d", i)
+	}
+	// 後の画像ほど早く読み終わるようにしても、結果はkeysの順序になる
+	imgList, infos, err := loadParallel(keys, LoadOptions{Workers: 8}, func(i int) (image.Image, ImageInfo, error) {
+		time.Sleep(time.Duration(len(keys)-i) * 200 * time.Microsecond)
+		return image.NewRGBA(image.Rect(0, 0, i+1, 1)), ImageInfo{Path: keys[i]}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range keys {
+		if infos[i].Path != keys[i] || imgList[i].Bounds().Dx() != i+1 {
+			t.Fatalf("result %d is %s (width %d), want %s (width %d)", i, infos[i].Path, imgList[i].Bounds().Dx(), keys[i], i+1)
+		}
+	}
+}
+
+func TestLoadParallelStopsOnError(t *testing.T) {
+	keys := make([]string, 200)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("img%03d", i)
+	}
+	var calls atomic.Int32
+	_, _, err := loadParallel(keys, LoadOptions{Workers: 4}, func(i int) (image.Image, ImageInfo, error) {
+		calls.Add(1)
+		if i == 0 {
+			return nil, ImageInfo{}, errors.New("broken")
+		}
+		time.Sleep(time.Millisecond)
+		return image.NewRGBA(image.Rect(0, 0, 1, 1)), ImageInfo{}, nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "img000") {
+		t.Fatalf("loadParallel error = %v, want an error naming img000", err)
+	}
+	// 最初のエラーで残りの画像を渡すのをやめる（読み込み中だったワーカーの分だけ進む）
+	if n := calls.Load(); n > 20 {
+		t.Errorf("loaded %d of %d images after the first error", n, len(keys))
+	}
+}
+
+// BenchmarkLoadImages は100枚の画像の読み込みを1ワーカーとCPU数のワーカーで比べる
+func BenchmarkLoadImages(b *testing.B) {
+	dir := b.TempDir()
+	paths := make([]string, 100)
+	for i := range paths {
+		img := image.NewRGBA(image.Rect(0, 0, 640, 480))
+		for p := range img.Pix {
+			img.Pix[p] = uint8(p*7 + i)
+		}
+		paths[i] = filepath.Join(dir, fmt.Sprintf("img%03d.png", i))
+		if err := writePNG(paths[i], img); err != nil {
+			b.Fatal(err)
+		}
+	}
+	// CPUが1つの環境では比べる相手がないため1ワーカーだけになる
+	for _, workers := range slices.Compact([]int{1, runtime.NumCPU()}) {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, _, err := LoadImagesWithInfo(paths, LoadOptions{Workers: workers}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package collage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"math"
+	"strings"
+)
+
+// pngHeaderLen はPNGのシグネチャ(8バイト)とIHDRチャンク(25バイト)の長さ
+// 解像度とテキストのチャンクはIHDRの直後に挟み込む
+const pngHeaderLen = 8 + 25
+
+// jpegSOI はJPEGの先頭のSOIマーカー（APP0とコメントはこの直後に挟み込む）
+var jpegSOI = []byte{0xff, 0xd8}
+
+// JPEGのコメントセグメントに書き込めるデータの最大長（長さフィールド自身の2バイトを除く）
+const maxJPEGComment = 0xffff - 2
+
+// metersPerInch は1インチのメートル数（PNGの解像度はメートルあたりのピクセル数で書く）
+const metersPerInch = 0.0254
+
+// pngChunks はcfg.DPIをpHYsチャンク、cfg.SoftwareをtEXtチャンク、cfg.SourcesをUTF-8のiTXtチャンクにする
+// 埋め込むものがなければnilを返す
+func pngChunks(cfg Config) []byte {
+	var buf bytes.Buffer
+	if cfg.DPI > 0 {
+		// X・Y方向のメートルあたりのピクセル数と単位（1: メートル）
+		ppm := uint32(math.Round(float64(cfg.DPI) / metersPerInch))
+		data := make([]byte, 9)
+		binary.BigEndian.PutUint32(data[0:], ppm)
+		binary.BigEndian.PutUint32(data[4:], ppm)
+		data[8] = 1
+		writePNGChunk(&buf, "pHYs", data)
+	}
+	if cfg.Software != "" {
+		writePNGChunk(&buf, "tEXt", []byte("Software\x00"+cfg.Software))
+	}
+	if len(cfg.Sources) > 0 {
+		// キーワード, 圧縮フラグ, 圧縮方式, 言語タグ, 翻訳されたキーワード, テキスト
+		data := "Description\x00\x00\x00\x00\x00" + strings.Join(cfg.Sources, "\n")
+		writePNGChunk(&buf, "iTXt", []byte(data))
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// writePNGChunk は長さ・種類・データ・CRCからなるPNGのチャンクを書き込む
+func writePNGChunk(w *bytes.Buffer, typ string, data []byte) {
+	binary.Write(w, binary.BigEndian, uint32(len(data)))
+	w.WriteString(typ)
+	w.Write(data)
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(typ))
+	crc.Write(data)
+	binary.Write(w, binary.BigEndian, crc.Sum32())
+}
+
+// jpegSegments はcfg.DPIをJFIFのAPP0セグメント、cfg.Softwareとcfg.Sourcesをコメントにする
+// （image/jpegはAPP0を書き込まないため、解像度を指定するときだけ追加する）。埋め込むものがなければnilを返す
+func jpegSegments(cfg Config) []byte {
+	var seg []byte
+	if cfg.DPI > 0 {
+		density := uint16(min(cfg.DPI, math.MaxUint16))
+		// 識別子, バージョン1.01, 単位（1: インチあたりのドット数）, X・Y密度, サムネイルなし
+		seg = []byte{0xff, 0xe0, 0, 16, 'J', 'F', 'I', 'F', 0, 1, 1, 1, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint16(seg[12:], density)
+		binary.BigEndian.PutUint16(seg[14:], density)
+	}
+	return append(seg, jpegComment(cfg)...)
+}
+
+// jpegComment はcfg.Softwareとcfg.SourcesをJPEGのコメント(COM)セグメントにする
+// 長すぎる場合は1セグメントに収まるよう切り詰め、埋め込むものがなければnilを返す
+func jpegComment(cfg Config) []byte {
+	var lines []string
+	if cfg.Software != "" {
+		lines = append(lines, "Software: "+cfg.Software)
+	}
+	if len(cfg.Sources) > 0 {
+		lines = append(lines, "Sources:")
+		lines = append(lines, cfg.Sources...)
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	text := strings.Join(lines, "\n")
+	if len(text) > maxJPEGComment {
+		text = strings.ToValidUTF8(text[:maxJPEGComment], "")
+	}
+	seg := []byte{0xff, 0xfe, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(text)+2))
+	return append(seg, text...)
+}
+
+// insertAfter はwへ書き込むデータの先頭からoffsetバイトの位置にdataを挟み込むWriterを返す
+// （エンコーダーの出力をバッファせずにメタデータを追加するため）。dataが空ならwをそのまま返す
+func insertAfter(w io.Writer, offset int, data []byte) io.Writer {
+	if len(data) == 0 {
+		return w
+	}
+	return &insertWriter{w: w, remaining: offset, data: data}
+}
+
+// insertWriter はremainingバイトを書き込んだ時点でdataを挟み込む
+type insertWriter struct {
+	w         io.Writer
+	remaining int
+	data      []byte
+}
+
+func (iw *insertWriter) Write(p []byte) (int, error) {
+	if iw.data == nil {
+		return iw.w.Write(p)
+	}
+	if len(p) < iw.remaining {
+		n, err := iw.w.Write(p)
+		iw.remaining -= n
+		return n, err
+	}
+	n, err := iw.w.Write(p[:iw.remaining])
+	if err != nil {
+		return n, err
+	}
+	if _, err := iw.w.Write(iw.data); err != nil {
+		return n, err
+	}
+	iw.data = nil
+	m, err := iw.w.Write(p[n:])
+	return n + m, err
+}
@@ -0,0 +1,61 @@
+package collage
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sync"
+
+	"golang.org/x/image/font"
+)
+
+// placeholderSize は代替画像の一辺（タイルに合わせてリサイズされる）
+const placeholderSize = 256
+
+// 代替画像の背景色と、エラー記号・ファイル名の色
+var (
+	placeholderColor     = color.RGBA{0xd0, 0xd0, 0xd0, 0xff}
+	placeholderTextColor = color.RGBA{0x70, 0x70, 0x70, 0xff}
+)
+
+// placeholderFaces は代替画像のエラー記号とファイル名のフォント
+// 読み込めない画像ごとにGoフォントを解析し直さないよう、最初に使うときに一度だけ作る
+var placeholderFaces = sync.OnceValues(func() ([2]font.Face, error) {
+	mark, err := GoFontFace(placeholderSize / 2)
+	if err != nil {
+		return [2]font.Face{}, err
+	}
+	name, err := GoFontFace(placeholderSize / 12)
+	if err != nil {
+		return [2]font.Face{}, err
+	}
+	return [2]font.Face{mark, name}, nil
+})
+
+// placeholderMu は共有するフォントでの描画を排他する（font.Faceは並行に使えず、代替画像は並列の読み込みから作られる）
+var placeholderMu sync.Mutex
+
+// Placeholder は読み込めなかった画像の代わりに、灰色の地にエラー記号（×）とnameを描いた画像を返す
+func Placeholder(name string) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, placeholderSize, placeholderSize))
+	draw.Draw(img, img.Bounds(), &image.Uniform{placeholderColor}, image.Point{}, draw.Src)
+
+	// フォントを読み込めない場合は無地のまま返す
+	faces, err := placeholderFaces()
+	if err != nil {
+		return img
+	}
+	glyph, face := faces[0], faces[1]
+	placeholderMu.Lock()
+	defer placeholderMu.Unlock()
+
+	const mark = "×"
+	w := font.MeasureString(glyph, mark).Ceil()
+	h := glyph.Metrics().Height.Ceil()
+	drawText(img, glyph, placeholderTextColor, (placeholderSize-w)/2, (placeholderSize-h)/2-placeholderSize/10, mark)
+
+	name = truncateText(face, name, placeholderSize-2*textPadding)
+	w = font.MeasureString(face, name).Ceil()
+	drawText(img, face, placeholderTextColor, (placeholderSize-w)/2, placeholderSize*3/4, name)
+	return img
+}
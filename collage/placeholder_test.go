@@ -0,0 +1,33 @@
+package collage
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+func TestPlaceholderConcurrent(t *testing.T) {
+	want := toRGBA(Placeholder("broken.jpg")).Pix
+	// 並列の読み込みから同時に作っても、共有のフォントで同じ画像になる
+	var wg sync.WaitGroup
+	results := make([][]byte, 8)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = toRGBA(Placeholder("broken.jpg")).Pix
+		}()
+	}
+	wg.Wait()
+	for i, got := range results {
+		if !bytes.Equal(got, want) {
+			t.Errorf("placeholder %d differs from the first one", i)
+		}
+	}
+}
+
+func BenchmarkPlaceholder(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		Placeholder("broken.jpg")
+	}
+}
@@ -0,0 +1,117 @@
+package collage
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gen2brain/webp"
+	"golang.org/x/image/tiff"
+)
+
+// ErrUnsupportedFormat は対応していない出力形式が指定された場合のエラー
+var ErrUnsupportedFormat = errors.New("unsupported output format")
+
+// FormatFromExt はファイル名の拡張子から出力形式（png / jpeg / webp / tiff / svg）を判定する
+// 対応していない拡張子の場合は空文字列を返す
+func FormatFromExt(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".png":
+		return "png"
+	case ".jpg", ".jpeg":
+		return "jpeg"
+	case ".webp":
+		return "webp"
+	case ".tif", ".tiff":
+		return "tiff"
+	case ".svg":
+		return "svg"
+	}
+	return ""
+}
+
+// Save はcfg.Format（未指定の場合は拡張子）でPNG/JPEG/WebP/TIFFを判定し保存する
+// （cfg.QualityはPNGでは無視）。filenameが "-" の場合は標準出力に書き出す
+func Save(filename string, img image.Image, cfg Config) error {
+	format := cfg.Format
+	if format == "" && filename != "-" {
+		format = FormatFromExt(filename)
+	}
+	// 書き出せない形式の場合は空のファイルを作らずにエラーにする
+	switch format {
+	case "png", "jpeg", "webp", "tiff":
+	default:
+		return ErrUnsupportedFormat
+	}
+	if filename == "-" {
+		return Encode(os.Stdout, img, format, cfg)
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := Encode(f, img, format, cfg); err != nil {
+		f.Close()
+		return err
+	}
+	// 書き込みエラーはCloseで初めて分かることがあるため無視しない
+	return f.Close()
+}
+
+// Encode は指定した形式（png / jpeg / webp / tiff）で画像をwに書き出す
+// svgはラスター画像から生成できないため ErrUnsupportedFormat になる（EncodeSVGを使う）
+func Encode(w io.Writer, img image.Image, format string, cfg Config) error {
+	switch format {
+	case "png":
+		enc, err := pngEncoder(cfg.PNGCompression)
+		if err != nil {
+			return err
+		}
+		return enc.Encode(insertAfter(w, pngHeaderLen, pngChunks(cfg)), img)
+	case "jpeg":
+		return jpeg.Encode(insertAfter(w, len(jpegSOI), jpegSegments(cfg)), img, &jpeg.Options{Quality: cfg.Quality})
+	case "webp":
+		return webp.Encode(w, img, webp.Options{Quality: cfg.Quality, Method: webp.DefaultMethod})
+	case "tiff":
+		opts, err := tiffOptions(cfg.TIFFCompression)
+		if err != nil {
+			return err
+		}
+		return tiff.Encode(w, img, opts)
+	}
+	return ErrUnsupportedFormat
+}
+
+// tiffOptions は圧縮方式名（none / deflate）をTIFFのエンコード設定に変換する
+// x/image/tiffはLZWでのエンコードに対応していない
+func tiffOptions(compression string) (*tiff.Options, error) {
+	switch compression {
+	case "", "none":
+		return &tiff.Options{Compression: tiff.Uncompressed}, nil
+	case "deflate":
+		return &tiff.Options{Compression: tiff.Deflate}, nil
+	}
+	return nil, fmt.Errorf("unsupported tiff compression %q", compression)
+}
+
+// pngEncoder は圧縮レベル名（default / speed / best / none）をPNGのエンコーダーに変換する
+func pngEncoder(compression string) (*png.Encoder, error) {
+	switch compression {
+	case "", "default":
+		return &png.Encoder{CompressionLevel: png.DefaultCompression}, nil
+	case "speed":
+		return &png.Encoder{CompressionLevel: png.BestSpeed}, nil
+	case "best":
+		return &png.Encoder{CompressionLevel: png.BestCompression}, nil
+	case "none":
+		return &png.Encoder{CompressionLevel: png.NoCompression}, nil
+	}
+	return nil, fmt.Errorf("unsupported png compression %q", compression)
+}
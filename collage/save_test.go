@@ -0,0 +1,62 @@
+package collage
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSave(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			src.SetRGBA(x, y, color.RGBA{uint8(x * 16), uint8(y * 32), 128, 255})
+		}
+	}
+
+	tests := []struct {
+		file   string
+		format string
+	}{
+		{"out.png", "png"},
+		{"out.jpg", "jpeg"},
+		{"out.webp", "webp"},
+		{"out.tiff", "tiff"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := Save(path, src, DefaultConfig()); err != nil {
+				t.Fatalf("Save: %v", err)
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			img, format, err := image.Decode(f)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if format != tt.format {
+				t.Errorf("format = %q, want %q", format, tt.format)
+			}
+			if got := img.Bounds().Size(); got != src.Bounds().Size() {
+				t.Errorf("size = %v, want %v", got, src.Bounds().Size())
+			}
+		})
+	}
+}
+
+func TestSaveUnsupportedFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.gif")
+	if err := Save(path, image.NewRGBA(image.Rect(0, 0, 4, 4)), DefaultConfig()); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("Save(%q) error = %v, want %v", path, err, ErrUnsupportedFormat)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Save(%q) created a file (stat error %v)", path, err)
+	}
+}
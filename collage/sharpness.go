@@ -0,0 +1,141 @@
+package collage
+
+import (
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/nfnt/resize"
+)
+
+// sharpnessSize は鮮明さを測る前に縮小する長辺のピクセル数
+// （小さすぎると縮小でぼけが目立たなくなるため、ある程度の大きさを残す）
+const sharpnessSize = 512
+
+// SharpestSelect はfilesの各画像の鮮明さを測り、鮮明なものから順にn件を返す
+// 鮮明さは縮小したグレースケール画像のラプラシアンの分散で、連写などの似た写真からピントの合ったものを選ぶのに使う。
+// 読み込みはopts.Workers並列で行い、opts.OnErrorが設定されていれば読み込めない画像は除外する
+// （何度も選ぶ場合はSharpnessSelectorを使う）
+func SharpestSelect(files []string, n int, opts LoadOptions) ([]string, error) {
+	return NewSharpnessSelector().Select(files, n, opts)
+}
+
+// SharpnessSelector は画像の鮮明さを測って鮮明なものから選ぶ
+// 測った鮮明さはパスごとに覚えておき、読み込めなかった画像の補充などで選び直しても同じ画像を測り直さない
+type SharpnessSelector struct {
+	mu sync.Mutex
+	// scores は測った鮮明さ、failed は読み込めず測れなかった画像
+	scores map[string]float64
+	failed map[string]bool
+}
+
+// NewSharpnessSelector は何も測っていないSharpnessSelectorを作る
+func NewSharpnessSelector() *SharpnessSelector {
+	return &SharpnessSelector{scores: map[string]float64{}, failed: map[string]bool{}}
+}
+
+// Select はSharpestSelectと同様にfilesから鮮明なものから順にn件を返す
+// まだ測っていない画像だけを縮小して測り、以前に読み込めなかった画像は選ばない。
+// 保持するのはパスと鮮明さだけのため、選んだ画像は呼び出し側で読み込み直す
+func (s *SharpnessSelector) Select(files []string, n int, opts LoadOptions) ([]string, error) {
+	s.mu.Lock()
+	var todo []string
+	for _, path := range files {
+		if _, ok := s.scores[path]; !ok && !s.failed[path] {
+			todo = append(todo, path)
+		}
+	}
+	s.mu.Unlock()
+
+	_, _, err := loadParallel(todo, opts, func(i int) (image.Image, ImageInfo, error) {
+		thumb, err := loadThumbnail(todo[i], sharpnessSize)
+		if err != nil {
+			return nil, ImageInfo{Path: todo[i], Name: filepath.Base(todo[i])}, err
+		}
+		score := laplacianVariance(thumb)
+		s.mu.Lock()
+		s.scores[todo[i]] = score
+		s.mu.Unlock()
+		return nil, ImageInfo{Path: todo[i]}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, path := range todo {
+		if _, ok := s.scores[path]; !ok {
+			s.failed[path] = true
+		}
+	}
+	var scored []string
+	for _, path := range files {
+		if _, ok := s.scores[path]; ok {
+			scored = append(scored, path)
+		}
+	}
+	sort.SliceStable(scored, func(a, b int) bool {
+		return s.scores[scored[a]] > s.scores[scored[b]]
+	})
+	return scored[:max(0, min(n, len(scored)))], nil
+}
+
+// loadThumbnail はpathの画像を長辺size以下に縮小して返す
+// 先にDecodeConfigでヘッダーだけを読んで画像でないファイルを除き、大きさから縮小が要るかを決める。
+// 標準のデコーダーは縮小しながらのデコードに対応しないため元の解像度でデコードするが、
+// すぐに縮小して元の画像は手放す（同時に保持する元の解像度の画像はワーカーごとに1枚）
+func loadThumbnail(path string, size int) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	// 鮮明さは向きによらないため、EXIFのOrientationは読まない
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	if c.Width <= size && c.Height <= size {
+		return img, nil
+	}
+	return resize.Thumbnail(uint(size), uint(size), img, resize.Bilinear), nil
+}
+
+// laplacianVariance は輝度に4近傍のラプラシアンフィルタをかけた応答の分散を返す（大きいほど鮮明）
+func laplacianVariance(img image.Image) float64 {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w < 3 || h < 3 {
+		return 0
+	}
+	gray := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			gray[y*w+x] = float64(luminance(img.At(b.Min.X+x, b.Min.Y+y))) / 0xffff
+		}
+	}
+
+	var sum, sumSq float64
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			i := y*w + x
+			v := gray[i-1] + gray[i+1] + gray[i-w] + gray[i+w] - 4*gray[i]
+			sum += v
+			sumSq += v * v
+		}
+	}
+	count := float64((w - 2) * (h - 2))
+	mean := sum / count
+	return sumSq/count - mean*mean
+}
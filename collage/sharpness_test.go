@@ -0,0 +1,88 @@
+package collage
+
+import (
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// writeTestPNG はsharpなら白黒の市松模様、そうでなければ一色の画像をdirに書き出す
+func writeTestPNG(t *testing.T, dir, name string, sharp bool) string {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			c := color.RGBA{128, 128, 128, 255}
+			if sharp && (x+y)%2 == 0 {
+				c = color.RGBA{255, 255, 255, 255}
+			} else if sharp {
+				c = color.RGBA{0, 0, 0, 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	path := filepath.Join(dir, name)
+	if err := writePNG(path, img); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSharpnessSelector(t *testing.T) {
+	dir := t.TempDir()
+	flat := writeTestPNG(t, dir, "flat.png", false)
+	sharp := writeTestPNG(t, dir, "sharp.png", true)
+	broken := filepath.Join(dir, "broken.png")
+	if err := os.WriteFile(broken, []byte("not an image"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	files := []string{flat, broken, sharp}
+	opts := LoadOptions{Workers: 2, OnError: func(string, error) {}}
+
+	s := NewSharpnessSelector()
+	got, err := s.Select(files, 2, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{sharp, flat}; !slices.Equal(got, want) {
+		t.Fatalf("Select = %v, want %v", got, want)
+	}
+
+	// 測り終えた画像のファイルを消しても、選び直しで読み込み直さない
+	if err := os.Remove(sharp); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = s.Select(files, 3, LoadOptions{Workers: 2}); err != nil {
+		t.Fatalf("Select again: %v", err)
+	}
+	if want := []string{sharp, flat}; !slices.Equal(got, want) {
+		t.Errorf("Select again = %v, want %v", got, want)
+	}
+}
+
+func TestLoadThumbnail(t *testing.T) {
+	dir := t.TempDir()
+	big := filepath.Join(dir, "big.png")
+	if err := writePNG(big, image.NewRGBA(image.Rect(0, 0, 1200, 600))); err != nil {
+		t.Fatal(err)
+	}
+	small := writeTestPNG(t, dir, "small.png", true)
+	for _, tt := range []struct {
+		path string
+		want image.Point
+	}{
+		{big, image.Pt(sharpnessSize, sharpnessSize/2)},
+		{small, image.Pt(32, 32)},
+	} {
+		thumb, err := loadThumbnail(tt.path, sharpnessSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := thumb.Bounds().Size(); got != tt.want {
+			t.Errorf("loadThumbnail(%s) size = %v, want %v", filepath.Base(tt.path), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package collage
+
+import (
+	"image"
+	"image/color"
+)
+
+// streamBandHeight はCreateStreamの画像が一度に描画する帯の高さ（ピクセル）
+const streamBandHeight = 256
+
+// CreateStream はCreateと同じコラージュを、キャンバス全体のバッファを持たない画像として返す
+// 画素は読み出された位置の帯（高さ256ピクセル）ごとに描画し、描画し終えたタイルのリサイズ済み画像は解放する。
+// PNG / JPEG / TIFF のように上から順に読み出すエンコーダーに渡すと、キャンバス全体のバッファの代わりに
+// 帯1本とタイル1行分のリサイズ済み画像だけを持てばよい（WebPはエンコーダーが全体を変換するため効果がない）。
+// 元画像はimgListとしてすべて保持したままのため、減るのはキャンバスのバッファの分だけになる。
+// 返す画像は並行に読み出してはいけない
+func CreateStream(imgList []image.Image, names []string, cfg Config) (image.Image, error) {
+	l, err := planLayout(imgList, names, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &streamImage{l: l, cfg: cfg}, nil
+}
+
+// streamImage は帯ごとに描画しながら画素を返す画像
+type streamImage struct {
+	l    *layout
+	cfg  Config
+	band *image.RGBA
+}
+
+func (m *streamImage) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+func (m *streamImage) Bounds() image.Rectangle {
+	return image.Rect(0, 0, m.l.width, m.l.height)
+}
+
+func (m *streamImage) At(x, y int) color.Color {
+	return m.RGBAAt(x, y)
+}
+
+// RGBAAt はAtと同じ画素をcolor.RGBAで返す
+func (m *streamImage) RGBAAt(x, y int) color.RGBA {
+	p := image.Pt(x, y)
+	if !p.In(m.Bounds()) {
+		return color.RGBA{}
+	}
+	if m.band == nil || !p.In(m.band.Rect) {
+		m.renderBand(y)
+	}
+	return m.band.RGBAAt(x, y)
+}
+
+// Opaque は背景色が不透明ならtrueを返す（PNGエンコーダーが透明度を調べるために全体を読み出さないように）
+func (m *streamImage) Opaque() bool {
+	return m.cfg.Background.A == 0xff
+}
+
+// renderBand はyを含む帯を描画し、それより上で描画し終えたタイルを解放する
+func (m *streamImage) renderBand(y int) {
+	y0 := y - y%streamBandHeight
+	r := image.Rect(0, y0, m.l.width, min(m.l.height, y0+streamBandHeight))
+	if m.band == nil {
+		m.band = image.NewRGBA(r)
+	} else {
+		// 帯のバッファは使い回す
+		m.band.Rect = r
+		m.band.Pix = m.band.Pix[:r.Dx()*r.Dy()*4]
+		clear(m.band.Pix)
+	}
+	m.l.render(m.band, m.cfg)
+	m.l.releaseTiles(y0, m.cfg.Margin)
+}
@@ -0,0 +1,130 @@
+package collage
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// testImages は縦横比と色の異なるn枚の画像と名前を作る
+func testImages(n int) ([]image.Image, []string) {
+	imgList := make([]image.Image, n)
+	names := make([]string, n)
+	for i := range imgList {
+		w, h := 120+40*(i%3), 90+50*(i%2)
+		img := image.NewRGBA(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				img.SetRGBA(x, y, color.RGBA{uint8(x * 2), uint8(y * 2), uint8(40 * i), 255})
+			}
+		}
+		imgList[i] = img
+		names[i] = string(rune('a'+i)) + ".png"
+	}
+	return imgList, names
+}
+
+func TestCreateStreamMatchesCreate(t *testing.T) {
+	imgList, names := testImages(6)
+	tests := []struct {
+		desc string
+		set  func(*Config)
+	}{
+		{"labels", func(c *Config) {}},
+		{"title", func(c *Config) { c.Title = "Collage" }},
+		{"radius", func(c *Config) { c.Radius = 20; c.Label = "none" }},
+		{"justified", func(c *Config) { c.Layout = "justified"; c.LabelPos = "above" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.N = 3
+			cfg.TileSize = 200
+			tt.set(&cfg)
+			want, err := Create(imgList, names, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := CreateStream(imgList, names, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Bounds() != want.Bounds() {
+				t.Fatalf("CreateStream bounds %v, Create bounds %v", got.Bounds(), want.Bounds())
+			}
+			// 帯の境界をまたいで上から順に読み出し、すべての画素が一致する
+			b := want.Bounds()
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					if g, w := got.At(x, y), want.At(x, y); color.RGBAModel.Convert(g) != color.RGBAModel.Convert(w) {
+						t.Fatalf("pixel (%d,%d) = %v, want %v", x, y, g, w)
+					}
+				}
+			}
+		})
+	}
+}
+
+// peakHeap はfの実行中のヒープ使用量の最大値を1ミリ秒ごとに調べて返す
+func peakHeap(f func()) uint64 {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	base := ms.HeapInuse
+
+	done := make(chan struct{})
+	peak := make(chan uint64)
+	go func() {
+		var max uint64
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			var ms runtime.MemStats
+			runtime.ReadMemStats(&ms)
+			if ms.HeapInuse > max {
+				max = ms.HeapInuse
+			}
+			select {
+			case <-done:
+				peak <- max
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	f()
+	close(done)
+	if p := <-peak; p > base {
+		return p - base
+	}
+	return 0
+}
+
+// benchmarkEncode は大きなキャンバスをPNGにエンコードし、ヒープの最大増加量をpeak-heap-B/opとして報告する
+func benchmarkEncode(b *testing.B, create func([]image.Image, []string, Config) (image.Image, error)) {
+	imgList, names := testImages(16)
+	cfg := DefaultConfig()
+	cfg.N = 4
+	cfg.TileSize = 1000
+	b.ReportAllocs()
+	var peak uint64
+	for i := 0; i < b.N; i++ {
+		peak = max(peak, peakHeap(func() {
+			img, err := create(imgList, names, cfg)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := png.Encode(io.Discard, img); err != nil {
+				b.Fatal(err)
+			}
+		}))
+	}
+	b.ReportMetric(float64(peak), "peak-heap-B/op")
+}
+
+func BenchmarkCreate(b *testing.B)       { benchmarkEncode(b, Create) }
+func BenchmarkCreateStream(b *testing.B) { benchmarkEncode(b, CreateStream) }
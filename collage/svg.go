@@ -0,0 +1,218 @@
+package collage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/font"
+)
+
+// SaveSVG はCreateと同じ配置のコラージュをSVGとして保存し、キャンバスの大きさを返す
+// filenameが "-" の場合は標準出力に書き出す。SVGはラスター画像ではなく配置から生成するため、Saveではなくこちらを使う
+func SaveSVG(filename string, imgList []image.Image, names []string, cfg Config) (image.Rectangle, error) {
+	if filename == "-" {
+		return EncodeSVG(os.Stdout, imgList, names, cfg)
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	r, err := EncodeSVG(f, imgList, names, cfg)
+	if err != nil {
+		f.Close()
+		return r, err
+	}
+	return r, f.Close()
+}
+
+// EncodeSVG はコラージュをSVG文書としてwに書き出し、キャンバスの大きさを返す
+// 各タイルはリサイズ済みのPNGをbase64で埋め込んだ<image>、ラベルとタイトルは<text>になる
+func EncodeSVG(w io.Writer, imgList []image.Image, names []string, cfg Config) (image.Rectangle, error) {
+	// 文字や背景は画素ではないため、キャンバス全体の色の加工は扱えない
+	if cfg.filtered() && cfg.FilterArea != "tiles" {
+		return image.Rectangle{}, errors.New("filtering the whole canvas is not supported for SVG; use the tiles filter area")
+	}
+	l, err := planLayout(imgList, names, cfg)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	canvas := image.Rect(0, 0, l.width, l.height)
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	fmt.Fprintf(bw, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">\n",
+		l.width, l.height, l.width, l.height)
+
+	drawShadows := cfg.Shadow && (cfg.Background.A != 0 || cfg.ShadowOnTransparent)
+	if drawShadows {
+		fmt.Fprintf(bw, "<defs><filter id=\"shadow\" x=\"-10%%\" y=\"-10%%\" width=\"130%%\" height=\"130%%\">"+
+			"<feDropShadow dx=\"%d\" dy=\"%d\" stdDeviation=\"%d\" flood-opacity=\"%.2f\"/></filter></defs>\n",
+			shadowOffset, shadowOffset, shadowRadius/2, float64(shadowOpacity)/0xff)
+	}
+
+	if cfg.Background.A != 0 {
+		fmt.Fprintf(bw, "<rect width=\"100%%\" height=\"100%%\" %s/>\n", svgPaint("fill", cfg.Background))
+	}
+	if l.background != nil {
+		if err := writeSVGImage(bw, l.background, l.background.Bounds().Sub(l.background.Bounds().Min), ""); err != nil {
+			return canvas, err
+		}
+	}
+	if l.titleFace != nil {
+		writeSVGText(bw, l.titleFace, cfg.TextColor, l.width/2, l.titleY, cfg.svgTitleFont(), "middle", l.title)
+	}
+	for i, line := range l.legend {
+		writeSVGText(bw, l.face, cfg.TextColor, l.legendAt.X, l.legendAt.Y+i*l.face.Metrics().Height.Ceil(), cfg.svgFont(), "start", line)
+	}
+
+	for k, t := range l.tiles {
+		attrs := ""
+		if drawShadows {
+			attrs = ` filter="url(#shadow)"`
+		}
+		if err := writeSVGImage(bw, l.tileImage(k, cfg), t.rect, attrs); err != nil {
+			return canvas, err
+		}
+		if cfg.BorderWidth > 0 {
+			// 枠線は描画領域の内側に収まるよう線幅の半分だけ内側に引く
+			bwf := float64(cfg.BorderWidth)
+			fmt.Fprintf(bw, "<rect x=\"%g\" y=\"%g\" width=\"%g\" height=\"%g\" rx=\"%g\" fill=\"none\" stroke-width=\"%d\" %s/>\n",
+				float64(t.rect.Min.X)+bwf/2, float64(t.rect.Min.Y)+bwf/2, float64(t.rect.Dx())-bwf, float64(t.rect.Dy())-bwf,
+				max(0, float64(cfg.Radius)-bwf/2), cfg.BorderWidth, svgPaint("stroke", cfg.BorderColor))
+		}
+		if cfg.Number {
+			chip := numberChip(l.face, t.rect.Min.Add(numberInset), t.number)
+			fmt.Fprintf(bw, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" %s/>\n",
+				chip.Min.X, chip.Min.Y, chip.Dx(), chip.Dy(), svgPaint("fill", cfg.TextColor))
+			writeSVGText(bw, l.face, chipTextColor(cfg.TextColor), chip.Min.X+numberChipPadding, chip.Min.Y+numberChipPadding/2,
+				cfg.svgFont(), "start", strconv.Itoa(t.number))
+		}
+
+		if t.label == "" {
+			continue
+		}
+		x, y, maxWidth := t.labelAt.X, t.labelAt.Y, t.box.Dx()
+		if cfg.LabelPos == "overlay" {
+			strip := image.Rect(t.rect.Min.X, t.rect.Max.Y-l.textHeight, t.rect.Max.X, t.rect.Max.Y).Intersect(t.rect)
+			if strip.Empty() {
+				continue
+			}
+			if cfg.LabelBackground.A == 0 {
+				fmt.Fprintf(bw, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" %s/>\n",
+					strip.Min.X, strip.Min.Y, strip.Dx(), strip.Dy(), svgPaint("fill", overlayColor))
+			}
+			x, maxWidth = strip.Min.X+labelBackgroundPadding, strip.Dx()-2*labelBackgroundPadding
+			y = strip.Min.Y + (strip.Dy()-lineCount(t.label)*l.face.Metrics().Height.Ceil())/2
+		}
+		lineHeight := l.face.Metrics().Height.Ceil()
+		for i, line := range strings.Split(t.label, "\n") {
+			line = truncateText(l.face, line, maxWidth)
+			if cfg.LabelBackground.A != 0 {
+				bg := labelBackground(l.face, x, y+i*lineHeight, line)
+				fmt.Fprintf(bw, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" %s/>\n",
+					bg.Min.X, bg.Min.Y, bg.Dx(), bg.Dy(), svgPaint("fill", cfg.LabelBackground))
+			}
+			writeSVGText(bw, l.face, cfg.TextColor, x, y+i*lineHeight, cfg.svgFont(), "start", line)
+		}
+	}
+
+	for _, r := range l.gridLines {
+		fmt.Fprintf(bw, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" %s/>\n",
+			r.Min.X, r.Min.Y, r.Dx(), r.Dy(), svgPaint("fill", cfg.GridColor))
+	}
+
+	if l.watermark != nil {
+		if err := writeSVGImage(bw, l.watermark, l.watermarkRect, fmt.Sprintf(" opacity=\"%.2f\"", cfg.WatermarkOpacity)); err != nil {
+			return canvas, err
+		}
+	}
+
+	fmt.Fprintf(bw, "</svg>\n")
+	return canvas, bw.Flush()
+}
+
+// writeSVGImage は画像をPNGとしてbase64で埋め込んだ<image>要素を矩形rの位置に書き出す
+func writeSVGImage(w io.Writer, img image.Image, r image.Rectangle, attrs string) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "<image x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\"%s href=\"data:image/png;base64,%s\"/>\n",
+		r.Min.X, r.Min.Y, r.Dx(), r.Dy(), attrs, base64.StdEncoding.EncodeToString(buf.Bytes()))
+	return err
+}
+
+// writeSVGText は上端がyの位置に1行のテキストを<text>要素として書き出す
+// （ラスター版と同じフォントの寸法からベースラインと文字サイズを決める）
+func writeSVGText(w io.Writer, face font.Face, c color.RGBA, x, y int, f svgFont, anchor, text string) {
+	m := face.Metrics()
+	fmt.Fprintf(w, "<text x=\"%d\" y=\"%d\" %s font-size=\"%d\" text-anchor=\"%s\" %s>",
+		x, y+m.Ascent.Ceil(), f.attrs(), (m.Ascent + m.Descent).Ceil(), anchor, svgPaint("fill", c))
+	xml.EscapeText(w, []byte(text))
+	fmt.Fprintf(w, "</text>\n")
+}
+
+// svgFont はSVGの<text>要素のフォント指定（font-familyの値と、regular / bold / italic / bold-italicのスタイル）
+type svgFont struct {
+	family string
+	style  string
+}
+
+// svgFont はファイル名・凡例・番号のフォント指定を返す（ラスター版のface()と同じフォントを名前で指定する）
+func (c Config) svgFont() svgFont {
+	switch {
+	case c.Font == nil:
+		return svgFont{family: "Inconsolata, monospace"}
+	case c.FontFamily != "":
+		return svgFont{family: cssFamily(c.FontFamily) + ", sans-serif", style: c.FontStyle}
+	}
+	return svgFont{family: "sans-serif", style: c.FontStyle}
+}
+
+// svgTitleFont はタイトルのフォント指定を返す（TitleFontがnilの場合はTitleStyleのGoフォント）
+func (c Config) svgTitleFont() svgFont {
+	if c.TitleFont != nil {
+		return svgFont{family: "sans-serif"}
+	}
+	return svgFont{family: "Go, sans-serif", style: c.TitleStyle}
+}
+
+// attrs はfont-family・font-weight・font-style属性を返す
+func (f svgFont) attrs() string {
+	var b strings.Builder
+	b.WriteString("font-family=\"")
+	b.WriteString(strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;").Replace(f.family))
+	b.WriteString("\"")
+	if strings.HasPrefix(f.style, "bold") {
+		b.WriteString(" font-weight=\"bold\"")
+	}
+	if strings.HasSuffix(f.style, "italic") {
+		b.WriteString(" font-style=\"italic\"")
+	}
+	return b.String()
+}
+
+// cssFamily はフォントのファミリー名をCSSの文字列として引用符で囲む
+func cssFamily(name string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(name) + "'"
+}
+
+// svgPaint はアルファ乗算済みの色をSVGの塗り属性（色と不透明度）に変換する
+func svgPaint(attr string, c color.RGBA) string {
+	nc := color.NRGBAModel.Convert(c).(color.NRGBA)
+	s := fmt.Sprintf("%s=\"#%02x%02x%02x\"", attr, nc.R, nc.G, nc.B)
+	if nc.A != 0xff {
+		s += fmt.Sprintf(" %s-opacity=\"%.3f\"", attr, float64(nc.A)/0xff)
+	}
+	return s
+}
@@ -0,0 +1,48 @@
+package collage
+
+import (
+	"bytes"
+	"image"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/font"
+)
+
+func TestSVGFontAttributes(t *testing.T) {
+	label, err := GoFontStyleFace("bold-italic", 16, font.HintingFull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		desc string
+		set  func(*Config)
+		want []string
+	}{
+		{"built-in font", func(*Config) {}, []string{`font-family="Inconsolata, monospace" font-size`}},
+		{"styled Go font", func(c *Config) {
+			c.Font, c.FontFamily, c.FontStyle = label, "Go", "bold-italic"
+		}, []string{`font-family="'Go', sans-serif" font-weight="bold" font-style="italic"`}},
+		{"font file", func(c *Config) {
+			c.Font, c.FontFamily = label, `Say "Hi" & Co`
+		}, []string{`font-family="'Say &quot;Hi&quot; &amp; Co', sans-serif" font-size`}},
+		{"title style", func(c *Config) {
+			c.Title, c.TitleStyle = "Title", "italic"
+		}, []string{`font-family="Go, sans-serif" font-style="italic" font-size`}},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.N = 1
+		cfg.TileSize = 20
+		tt.set(&cfg)
+		var buf bytes.Buffer
+		if _, err := EncodeSVG(&buf, []image.Image{image.NewRGBA(image.Rect(0, 0, 4, 4))}, []string{"a.png"}, cfg); err != nil {
+			t.Fatalf("%s: %v", tt.desc, err)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(buf.String(), want) {
+				t.Errorf("%s: SVG does not contain %s:\n%s", tt.desc, want, buf.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,365 @@
+package collage
+
+import (
+	"encoding/csv"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gobolditalic"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/inconsolata" // Inconsolataフォントを使用
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// テキスト描画用のデフォルトのフォント（Inconsolataを使用）
+var textFont font.Face = inconsolata.Regular8x16
+
+// テキスト領域の高さを自動計算する際にフォントの高さへ加える余白
+const textPadding = 4
+
+// GoFontFace はGoフォント(Go Regular)を指定ポイントサイズで読み込む
+func GoFontFace(size float64) (font.Face, error) {
+	return newFace(goregular.TTF, size, font.HintingFull)
+}
+
+// ParseHinting はヒンティングの名前（full / vertical / none）をfont.Hintingに変換する（空はfull）
+// noneはグリフの送り幅を整数ピクセルに丸めないため、文字が端数の位置に置かれカーニングが正確になる
+func ParseHinting(s string) (font.Hinting, error) {
+	switch s {
+	case "", "full":
+		return font.HintingFull, nil
+	case "vertical":
+		return font.HintingVertical, nil
+	case "none":
+		return font.HintingNone, nil
+	}
+	return font.HintingNone, fmt.Errorf("unknown hinting %q", s)
+}
+
+// GoFontStyleFace はGoフォントのスタイル（regular / bold / italic / bold-italic）を指定ポイントサイズとヒンティングで読み込む
+func GoFontStyleFace(style string, size float64, hinting font.Hinting) (font.Face, error) {
+	var data []byte
+	switch style {
+	case "", "regular":
+		data = goregular.TTF
+	case "bold":
+		data = gobold.TTF
+	case "italic":
+		data = goitalic.TTF
+	case "bold-italic":
+		data = gobolditalic.TTF
+	default:
+		return nil, fmt.Errorf("unknown font style %q", style)
+	}
+	return newFace(data, size, hinting)
+}
+
+// LoadFontFace は.ttf/.otfファイルを読み込み指定ポイントサイズとヒンティングのfont.Faceを作る
+func LoadFontFace(path string, size float64, hinting font.Hinting) (font.Face, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	face, err := newFace(data, size, hinting)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse font %s: %w", path, err)
+	}
+	return face, nil
+}
+
+// FontFamily は.ttf/.otf/.ttcファイルのフォントのファミリー名を返す（SVGのfont-familyに使う）
+// フォントコレクション(.ttc)の場合はLoadFontFaceと同じく最初のフォントの名前になる
+func FontFamily(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	c, err := opentype.ParseCollection(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse font %s: %w", path, err)
+	}
+	f, err := c.Font(0)
+	if err != nil {
+		return "", err
+	}
+	return f.Name(nil, sfnt.NameIDFamily)
+}
+
+// newFace はTrueType/OpenTypeフォントのデータから指定サイズのfont.Faceを作る
+// フォントコレクション(.ttc)の場合は最初のフォントを使う
+func newFace(data []byte, size float64, hinting font.Hinting) (font.Face, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid font size %v", size)
+	}
+	c, err := opentype.ParseCollection(data)
+	if err != nil {
+		return nil, err
+	}
+	f, err := c.Font(0)
+	if err != nil {
+		return nil, err
+	}
+	return opentype.NewFace(f, &opentype.FaceOptions{
+		Size:    size,
+		DPI:     72,
+		Hinting: hinting,
+	})
+}
+
+// fallbackFontPaths は日本語を含むラベルのために探す各OSの標準的なCJKフォント
+var fallbackFontPaths = []string{
+	"/usr/share/fonts/opentype/noto/NotoSansCJK-Regular.ttc",
+	"/usr/share/fonts/noto-cjk/NotoSansCJK-Regular.ttc",
+	"/usr/share/fonts/google-noto-cjk/NotoSansCJK-Regular.ttc",
+	"/usr/share/fonts/truetype/fonts-japanese-gothic.ttf",
+	"/usr/share/fonts/opentype/ipafont-gothic/ipag.ttf",
+	"/System/Library/Fonts/ヒラギノ角ゴシック W3.ttc",
+	"/System/Library/Fonts/Hiragino Sans GB.ttc",
+	"C:\\Windows\\Fonts\\YuGothM.ttc",
+	"C:\\Windows\\Fonts\\msgothic.ttc",
+}
+
+// FindFallbackFont はシステムにインストールされたCJKフォントを探し、最初に見つかったパスを返す
+// 見つからない場合は空文字列を返す
+func FindFallbackFont() string {
+	for _, path := range fallbackFontPaths {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// NeedsFallback はtextにInconsolataなどの欧文フォントにない文字（ASCII以外）が含まれるか判定する
+func NeedsFallback(text string) bool {
+	for _, r := range text {
+		if r > unicode.MaxASCII {
+			return true
+		}
+	}
+	return false
+}
+
+// fallbackFace はprimaryにない文字をfallbackで描画するfont.Face
+// 行の高さは両方のフォントが収まるよう大きい方に合わせる
+type fallbackFace struct {
+	primary, fallback font.Face
+}
+
+// pick は文字rを描画するフォントを返す
+func (f *fallbackFace) pick(r rune) font.Face {
+	if _, ok := f.primary.GlyphAdvance(r); ok {
+		return f.primary
+	}
+	if _, ok := f.fallback.GlyphAdvance(r); ok {
+		return f.fallback
+	}
+	return f.primary
+}
+
+func (f *fallbackFace) Glyph(dot fixed.Point26_6, r rune) (image.Rectangle, image.Image, image.Point, fixed.Int26_6, bool) {
+	return f.pick(r).Glyph(dot, r)
+}
+
+func (f *fallbackFace) GlyphBounds(r rune) (fixed.Rectangle26_6, fixed.Int26_6, bool) {
+	return f.pick(r).GlyphBounds(r)
+}
+
+func (f *fallbackFace) GlyphAdvance(r rune) (fixed.Int26_6, bool) {
+	return f.pick(r).GlyphAdvance(r)
+}
+
+func (f *fallbackFace) Kern(r0, r1 rune) fixed.Int26_6 {
+	if face := f.pick(r0); face == f.pick(r1) {
+		return face.Kern(r0, r1)
+	}
+	return 0
+}
+
+func (f *fallbackFace) Metrics() font.Metrics {
+	m, fm := f.primary.Metrics(), f.fallback.Metrics()
+	m.Ascent = max(m.Ascent, fm.Ascent)
+	m.Descent = max(m.Descent, fm.Descent)
+	m.Height = max(m.Height, fm.Height, m.Ascent+m.Descent)
+	return m
+}
+
+// Close は何もしない（元のフォントは呼び出し側が管理する）
+func (f *fallbackFace) Close() error {
+	return nil
+}
+
+// textBandHeight はフォントの高さと行数からテキスト領域の高さを求める
+func textBandHeight(face font.Face, lines int) int {
+	return max(1, lines)*face.Metrics().Height.Ceil() + textPadding
+}
+
+// lineCount はラベルの行数（改行区切り）を返す
+func lineCount(text string) int {
+	return strings.Count(text, "\n") + 1
+}
+
+// drawText はイメージ上にテキストを描画する
+// 書き始めの位置だけを整数ピクセルにそろえ、以降はフォントの送り幅とカーニングに従って端数の位置に進める
+func drawText(img draw.Image, face font.Face, c color.Color, x, y int, text string) {
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(c),
+		Face: face,
+		Dot: fixed.Point26_6{
+			X: fixed.I(x),
+			Y: fixed.I(y + face.Metrics().Ascent.Ceil()),
+		},
+	}
+	d.DrawString(text)
+}
+
+// LoadCaptions は "ファイル名,キャプション" 形式のCSVを読み込み、ファイル名（ベース名）からキャプションへの対応を返す
+func LoadCaptions(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse captions %s: %w", path, err)
+	}
+	captions := make(map[string]string, len(records))
+	for _, rec := range records {
+		if len(rec) < 2 {
+			continue
+		}
+		captions[filepath.Base(strings.TrimSpace(rec[0]))] = strings.TrimSpace(rec[1])
+	}
+	return captions, nil
+}
+
+// drawLabel は改行区切りのラベルを1行ずつ、幅maxWidthに収まるよう切り詰めて描画する
+func drawLabel(img draw.Image, face font.Face, c, bg color.RGBA, x, y, maxWidth int, text string) {
+	lineHeight := face.Metrics().Height.Ceil()
+	for i, line := range strings.Split(text, "\n") {
+		line = truncateText(face, line, maxWidth)
+		if bg.A != 0 {
+			draw.Draw(img, labelBackground(face, x, y+i*lineHeight, line), &image.Uniform{bg}, image.Point{}, draw.Over)
+		}
+		drawText(img, face, c, x, y+i*lineHeight, line)
+	}
+}
+
+// labelBackgroundPadding はラベルの背景を文字の左右に広げる幅
+const labelBackgroundPadding = 2
+
+// labelBackground は位置(x, y)に描画する1行のラベルlineの背後に敷く矩形（文字の幅と左右の余白）を返す
+func labelBackground(face font.Face, x, y int, line string) image.Rectangle {
+	w := font.MeasureString(face, line).Ceil()
+	return image.Rect(x-labelBackgroundPadding, y, x+w+labelBackgroundPadding, y+face.Metrics().Height.Ceil())
+}
+
+// formatLabel はラベルモード（full / noext / none）に従って表示する文字列を作る
+func formatLabel(name, mode string) string {
+	switch mode {
+	case "noext":
+		return strings.TrimSuffix(name, filepath.Ext(name))
+	case "none":
+		return ""
+	}
+	return name
+}
+
+// truncateText はmaxWidthピクセルに収まるよう末尾を省略記号で切り詰める
+func truncateText(face font.Face, text string, maxWidth int) string {
+	const ellipsis = "…"
+	limit := fixed.I(maxWidth)
+	if font.MeasureString(face, text) <= limit {
+		return text
+	}
+	runes := []rune(text)
+	for n := len(runes) - 1; n > 0; n-- {
+		s := string(runes[:n]) + ellipsis
+		if font.MeasureString(face, s) <= limit {
+			return s
+		}
+	}
+	return ""
+}
+
+// wrapEntries は項目を空白で区切って1行に並べ、maxWidthを超える前に次の行へ折り返す
+// 1項目だけで幅を超える場合は省略記号で切り詰める
+func wrapEntries(face font.Face, entries []string, maxWidth int) []string {
+	const sep = "   "
+	var lines []string
+	line := ""
+	for _, e := range entries {
+		e = truncateText(face, e, maxWidth)
+		if line != "" && font.MeasureString(face, line+sep+e) <= fixed.I(maxWidth) {
+			line += sep + e
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+		line = e
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// オーバーレイ表示するラベルの背景（半透明の白）
+var overlayColor = color.RGBA{0xc0, 0xc0, 0xc0, 0xc0}
+
+// drawOverlayLabel は画像領域rの下端に半透明の帯を敷き、その上にラベルを描画する
+// bgが透明でなければ帯の代わりに文字の幅だけbgの背景を敷く
+func drawOverlayLabel(img draw.Image, face font.Face, c, bg color.RGBA, r image.Rectangle, height int, text string) {
+	strip := image.Rect(r.Min.X, r.Max.Y-height, r.Max.X, r.Max.Y).Intersect(r)
+	if strip.Empty() {
+		return
+	}
+	if bg.A == 0 {
+		draw.Draw(img, strip, &image.Uniform{overlayColor}, image.Point{}, draw.Over)
+	}
+	ty := strip.Min.Y + (strip.Dy()-lineCount(text)*face.Metrics().Height.Ceil())/2
+	drawLabel(img, face, c, bg, strip.Min.X+labelBackgroundPadding, ty, strip.Dx()-2*labelBackgroundPadding, text)
+}
+
+// numberChipPadding は番号チップの文字の周囲の余白
+const numberChipPadding = 3
+
+// numberChip は位置ptに描画する番号nのチップの矩形を返す
+func numberChip(face font.Face, pt image.Point, n int) image.Rectangle {
+	w := font.MeasureString(face, strconv.Itoa(n)).Ceil() + 2*numberChipPadding
+	h := face.Metrics().Height.Ceil() + numberChipPadding
+	return image.Rectangle{Min: pt, Max: pt.Add(image.Pt(w, h))}
+}
+
+// chipTextColor は背景cの上で読みやすい文字色（白または黒）を返す
+func chipTextColor(c color.Color) color.RGBA {
+	if luminance(c) > 0x8000 {
+		return color.RGBA{0, 0, 0, 0xff}
+	}
+	return color.RGBA{0xff, 0xff, 0xff, 0xff}
+}
+
+// drawNumberChip は位置ptに色cのチップを敷き、その上に対照的な色で番号nを描画する
+func drawNumberChip(img draw.Image, face font.Face, c color.RGBA, pt image.Point, n int) {
+	chip := numberChip(face, pt, n)
+	draw.Draw(img, chip, &image.Uniform{c}, image.Point{}, draw.Over)
+	drawText(img, face, chipTextColor(c), chip.Min.X+numberChipPadding, chip.Min.Y+numberChipPadding/2, strconv.Itoa(n))
+}
@@ -0,0 +1,105 @@
+package collage
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// blockFace はどの文字も塗りつぶした矩形として描画するテスト用のフォント（日本語フォントの代わり）
+type blockFace struct{}
+
+func (blockFace) Glyph(dot fixed.Point26_6, r rune) (image.Rectangle, image.Image, image.Point, fixed.Int26_6, bool) {
+	x, y := dot.X.Round(), dot.Y.Round()
+	return image.Rect(x, y-10, x+8, y), image.Opaque, image.Point{}, fixed.I(10), true
+}
+
+func (blockFace) GlyphBounds(r rune) (fixed.Rectangle26_6, fixed.Int26_6, bool) {
+	return fixed.R(0, -10, 8, 0), fixed.I(10), true
+}
+
+func (blockFace) GlyphAdvance(r rune) (fixed.Int26_6, bool) { return fixed.I(10), true }
+func (blockFace) Kern(r0, r1 rune) fixed.Int26_6            { return 0 }
+func (blockFace) Close() error                              { return nil }
+func (blockFace) Metrics() font.Metrics {
+	return font.Metrics{Height: fixed.I(12), Ascent: fixed.I(10), Descent: fixed.I(2), CapHeight: fixed.I(10), XHeight: fixed.I(6)}
+}
+
+func TestTitleFaceFallback(t *testing.T) {
+	cfg := DefaultConfig()
+	face, err := cfg.titleFace()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := face.GlyphAdvance('写'); ok {
+		t.Fatal("Go font unexpectedly has a glyph for 写")
+	}
+
+	cfg.FallbackFont = blockFace{}
+	if face, err = cfg.titleFace(); err != nil {
+		t.Fatal(err)
+	}
+	if adv, ok := face.GlyphAdvance('写'); !ok || adv != fixed.I(10) {
+		t.Errorf("title face advance for 写 = %v, %v; want fallback advance %v", adv, ok, fixed.I(10))
+	}
+	if adv, _ := face.GlyphAdvance('A'); adv == fixed.I(10) {
+		t.Error("title face uses the fallback for A")
+	}
+}
+
+func TestRenderJapaneseName(t *testing.T) {
+	tile := image.NewRGBA(image.Rect(0, 0, 40, 40))
+	draw.Draw(tile, tile.Bounds(), &image.Uniform{color.RGBA{200, 200, 200, 255}}, image.Point{}, draw.Src)
+	red := color.RGBA{255, 0, 0, 255}
+
+	// 文字色そのままの画素数を数える（blockFaceの文字は1文字あたり8x10画素すべてが文字色になる）
+	textPixels := func(name, title string, fallback font.Face) int {
+		t.Helper()
+		cfg := DefaultConfig()
+		cfg.N = 1
+		cfg.TileSize = 40
+		cfg.TextColor = red
+		cfg.Title = title
+		cfg.FallbackFont = fallback
+		img, err := Create([]image.Image{tile}, []string{name}, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rgba := toRGBA(img)
+		n := 0
+		for y := rgba.Rect.Min.Y; y < rgba.Rect.Max.Y; y++ {
+			for x := rgba.Rect.Min.X; x < rgba.Rect.Max.X; x++ {
+				if rgba.RGBAAt(x, y) == red {
+					n++
+				}
+			}
+		}
+		return n
+	}
+
+	tests := []struct {
+		name, title string
+		// fallback は代替フォントで描画されるべき文字数
+		fallback int
+	}{
+		{"写真.png", "", 2},
+		{"a.png", "夏休み", 3},
+		{"写真.png", "夏休み", 5},
+		{"a.png", "Summer", 0},
+	}
+	for _, tt := range tests {
+		without := textPixels(tt.name, tt.title, nil)
+		with := textPixels(tt.name, tt.title, blockFace{})
+		if tt.fallback == 0 && with != without {
+			t.Errorf("name %q title %q: fallback changed text pixels from %d to %d", tt.name, tt.title, without, with)
+		}
+		// 代替フォントがなければ欠けた文字は豆腐（枠だけ）になるため、塗りつぶした文字の分だけ増える
+		if tt.fallback > 0 && with-without < tt.fallback*8*10/2 {
+			t.Errorf("name %q title %q: text pixels %d with fallback, %d without; want about %d more", tt.name, tt.title, with, without, tt.fallback*8*10)
+		}
+	}
+}
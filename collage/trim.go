@@ -0,0 +1,61 @@
+package collage
+
+import (
+	"image"
+	"image/color"
+)
+
+// trimTolerance は余白とみなす色と左上隅の色との、各チャンネルの差（0〜0xffff）の上限
+// （スキャン画像の紙の地のむらやJPEGのノイズを吸収できる程度にする）
+const trimTolerance = 0x1800
+
+// trimBorders は画像の四辺から、左上隅の色とほぼ同じ色だけが続く行・列を内側に向かって取り除いた画像を返す
+// 画像全体がほぼ単色の場合は切り取らずにそのまま返す
+func trimBorders(img image.Image) image.Image {
+	b := img.Bounds()
+	if b.Empty() {
+		return img
+	}
+	corner := color.RGBA64Model.Convert(img.At(b.Min.X, b.Min.Y)).(color.RGBA64)
+	uniform := func(x0, y0, x1, y1 int) bool {
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				if !nearColor(img.At(x, y), corner) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	r := b
+	for r.Min.Y < r.Max.Y && uniform(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+1) {
+		r.Min.Y++
+	}
+	if r.Empty() {
+		return img
+	}
+	for uniform(r.Min.X, r.Max.Y-1, r.Max.X, r.Max.Y) {
+		r.Max.Y--
+	}
+	for uniform(r.Min.X, r.Min.Y, r.Min.X+1, r.Max.Y) {
+		r.Min.X++
+	}
+	for uniform(r.Max.X-1, r.Min.Y, r.Max.X, r.Max.Y) {
+		r.Max.X--
+	}
+	if r == b {
+		return img
+	}
+	return subImage(img, r)
+}
+
+// nearColor はcの各チャンネルがrefとtrimTolerance以内の差か判定する
+func nearColor(c color.Color, ref color.RGBA64) bool {
+	r, g, b, a := c.RGBA()
+	near := func(v uint32, w uint16) bool {
+		d := int(v) - int(w)
+		return d <= trimTolerance && d >= -trimTolerance
+	}
+	return near(r, ref.R) && near(g, ref.G) && near(b, ref.B) && near(a, ref.A)
+}
@@ -0,0 +1,52 @@
+package collage
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/nfnt/resize"
+)
+
+// 透かしの大きさの上限（キャンバスの幅・高さに対する割合の逆数）
+const watermarkFraction = 4
+
+// drawWatermark は縮小済みの透かし画像markをrの位置に不透明度opacityで合成する
+func drawWatermark(dst draw.Image, mark image.Image, r image.Rectangle, opacity float64) {
+	mask := &image.Uniform{color.Alpha{uint8(opacity * 0xff)}}
+	draw.DrawMask(dst, r, mark, mark.Bounds().Min, mask, image.Point{}, draw.Over)
+}
+
+// placeWatermark は透かし画像markを縮小し、キャンバス上の配置位置とともに返す
+// 透かしはキャンバスの幅・高さの1/4に収まるよう縮小する（拡大はしない）
+func placeWatermark(canvas image.Rectangle, mark image.Image, pos string, opacity float64, margin int) (image.Image, image.Rectangle, error) {
+	if opacity < 0 || opacity > 1 {
+		return nil, image.Rectangle{}, fmt.Errorf("invalid watermark opacity %v", opacity)
+	}
+
+	maxW, maxH := canvas.Dx()/watermarkFraction, canvas.Dy()/watermarkFraction
+	if mb := mark.Bounds(); mb.Dx() > maxW || mb.Dy() > maxH {
+		mark = resize.Thumbnail(uint(maxW), uint(maxH), mark, resize.Lanczos3)
+	}
+	mb := mark.Bounds()
+	w, h := mb.Dx(), mb.Dy()
+
+	// 配置位置（t/b: 上下、l/r: 左右）
+	var x, y int
+	switch pos {
+	case "center":
+		x, y = (canvas.Dx()-w)/2, (canvas.Dy()-h)/2
+	case "tl":
+		x, y = margin, margin
+	case "tr":
+		x, y = canvas.Dx()-w-margin, margin
+	case "bl":
+		x, y = margin, canvas.Dy()-h-margin
+	case "br":
+		x, y = canvas.Dx()-w-margin, canvas.Dy()-h-margin
+	default:
+		return nil, image.Rectangle{}, fmt.Errorf("unknown watermark position %q", pos)
+	}
+	return mark, image.Rect(x, y, x+w, y+h).Add(canvas.Min), nil
+}
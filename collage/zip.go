@@ -0,0 +1,124 @@
+package collage
+
+import (
+	"archive/zip"
+	"image"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// ZipArchive は画像の読み込み元にするzipアーカイブ
+// エントリはアーカイブ内のパス（"dir/a.jpg" の形式）で指定する
+type ZipArchive struct {
+	r       *zip.ReadCloser
+	entries map[string]*zip.File
+}
+
+// OpenZip はzipアーカイブを開く（使い終わったらCloseで閉じる）
+func OpenZip(name string) (*ZipArchive, error) {
+	r, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, err
+	}
+	z := &ZipArchive{r: r, entries: make(map[string]*zip.File, len(r.File))}
+	for _, f := range r.File {
+		z.entries[f.Name] = f
+	}
+	return z, nil
+}
+
+// Close はアーカイブを閉じる
+func (z *ZipArchive) Close() error {
+	return z.r.Close()
+}
+
+// ImageFiles はアーカイブ内の画像エントリ一覧をアーカイブ内の順序で返す
+// ネストしたディレクトリ内のエントリも含め、optsの隠しファイル・除外パターン・
+// サイズ・寸法・件数の条件をGetImageFilesと同様に適用する（Recursiveがfalseの場合は最上位のみ）
+func (z *ZipArchive) ImageFiles(opts ScanOptions) []string {
+	var files []string
+	for _, f := range z.r.File {
+		if f.FileInfo().IsDir() || !IsImageFile(f.Name) {
+			continue
+		}
+		if !opts.Recursive && strings.Contains(f.Name, "/") {
+			continue
+		}
+		if opts.SkipHidden && hiddenZipPath(f.Name) {
+			continue
+		}
+		if opts.excluded(f.Name) || !z.accept(f, opts) {
+			continue
+		}
+		files = append(files, f.Name)
+		if opts.Limit > 0 && len(files) >= opts.Limit {
+			break
+		}
+	}
+	return files
+}
+
+// accept はエントリがサイズ・寸法の条件を満たすか判定する
+// （寸法を読み取れないエントリは後の読み込みでエラーにするため残す）
+func (z *ZipArchive) accept(f *zip.File, opts ScanOptions) bool {
+	size := int64(f.UncompressedSize64)
+	if opts.MinSize > 0 && size < opts.MinSize {
+		return false
+	}
+	if opts.MaxSize > 0 && size > opts.MaxSize {
+		return false
+	}
+	if opts.MinWidth > 0 || opts.MinHeight > 0 {
+		rc, err := f.Open()
+		if err != nil {
+			return true
+		}
+		defer rc.Close()
+		c, _, err := image.DecodeConfig(rc)
+		if err != nil {
+			return true
+		}
+		if c.Width < opts.MinWidth || c.Height < opts.MinHeight {
+			return false
+		}
+	}
+	return true
+}
+
+// LoadImages はアーカイブ内のエントリnamesをopts.Workers並列で読み込む
+// LoadImagesWithInfoと同様にメタデータを返す（Pathはアーカイブ内のパス）。opts.Cacheは使わない
+func (z *ZipArchive) LoadImages(names []string, opts LoadOptions) ([]image.Image, []ImageInfo, error) {
+	return loadParallel(names, opts, func(i int) (image.Image, ImageInfo, error) {
+		info := ImageInfo{Path: names[i], Name: path.Base(names[i])}
+		f, ok := z.entries[names[i]]
+		if !ok {
+			return nil, info, fs.ErrNotExist
+		}
+		info.Size = int64(f.UncompressedSize64)
+		info.ModTime = f.Modified
+		rc, err := f.Open()
+		if err != nil {
+			return nil, info, err
+		}
+		defer rc.Close()
+		img, format, taken, err := decodeImage(rc)
+		if err != nil {
+			return nil, info, err
+		}
+		info.Format, info.Taken = format, taken
+		info.Width, info.Height = img.Bounds().Dx(), img.Bounds().Dy()
+		return limitDimension(img, opts.MaxDimension), info, nil
+	})
+}
+
+// hiddenZipPath はアーカイブ内のパスのいずれかの要素が隠しファイル・ディレクトリか判定する
+// （macOSが作る __MACOSX/ も隠しディレクトリとして扱う）
+func hiddenZipPath(name string) bool {
+	for _, elem := range strings.Split(name, "/") {
+		if isHidden(elem) || elem == "__MACOSX" {
+			return true
+		}
+	}
+	return false
+}
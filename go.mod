@@ -3,6 +3,15 @@ module example.com/collage
 go 1.23
 
 require (
+	github.com/gen2brain/webp v0.5.5
+	github.com/jdeng/goheif v0.1.2
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
+	github.com/rwcarlsen/goexif v0.0.0-20190401172101-9e8deecbddbd
 	golang.org/x/image v0.8.0
 )
+
+require (
+	github.com/ebitengine/purego v0.8.3 // indirect
+	github.com/tetratelabs/wazero v1.9.0 // indirect
+	golang.org/x/text v0.10.0 // indirect
+)
@@ -1,236 +1,1280 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"image"
 	"image/color"
-	"image/draw"
-	"image/jpeg"
-	"image/png"
-	"io/fs"
+	"io"
 	"log"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
-	// BMP, GIFなど各種画像形式対応
-	_ "image/gif"
-
-	_ "golang.org/x/image/bmp"
-
-	"github.com/nfnt/resize"
+	"example.com/collage/collage"
 	"golang.org/x/image/font"
-	"golang.org/x/image/font/inconsolata" // Inconsolataフォントを使用
-	"golang.org/x/image/math/fixed"
-)
-
-// 対応拡張子
-var supportedExt = []string{".jpg", ".jpeg", ".png", ".gif", ".bmp"}
-
-// テキスト描画用設定（Inconsolataを使用）
-var (
-	textFont  font.Face = inconsolata.Regular8x16
-	textColor           = color.Black
 )
 
 func main() {
 	dir := flag.String("dir", "", "Input directory containing images")
-	output := flag.String("out", "output.png", "Output file name (png or jpg)")
+	globPattern := flag.String("glob", "", "Glob pattern selecting input images (e.g. \"photos/2023-*.jpg\"), instead of -dir")
+	fromStdin := flag.Bool("stdin", false, "Read newline-separated image paths from standard input, instead of -dir")
+	listPath := flag.String("list", "", "File of image paths (one per line) to use verbatim and in order, instead of -dir and random selection")
+	zipPath := flag.String("zip", "", "Zip archive to read images from (nested directories are included), instead of -dir")
+	recursive := flag.Bool("recursive", true, "Descend into subdirectories of -dir")
+	skipHidden := flag.Bool("skip-hidden", true, "Skip files and directories whose names begin with \".\" while scanning -dir")
+	exclude := flag.String("exclude", "", "Comma-separated glob patterns; skip files whose base name matches any of them (e.g. \"._*,thumb_*\")")
+	minSize := flag.String("min-size", "", "Skip images smaller than this file size in bytes (k/m suffixes allowed, e.g. 50k)")
+	maxSize := flag.String("max-size", "", "Skip images larger than this file size in bytes (k/m suffixes allowed, e.g. 20m)")
+	minWidth := flag.Int("min-width", 0, "Skip images narrower than this many pixels (read from the file header)")
+	minHeight := flag.Int("min-height", 0, "Skip images shorter than this many pixels (read from the file header)")
+	limit := flag.Int("limit", 0, "Stop scanning -dir after this many images are found (0 scans everything)")
+	outTemplate := flag.String("out-template", "", "Output file name template with {index} (page number), {date} (YYYY-MM-DD), {count} (tiles) and {seed}, e.g. \"sheet-{date}-{index}.png\"; replaces -out")
+	output := flag.String("out", "output.png", "Output file name (png, jpg, webp or tiff); \"-\" writes to stdout using -format")
 	nValue := flag.Int("n", 3, "Number of images per row/column (n×n collage)")
+	rowsValue := flag.Int("rows", 0, "Number of rows (overrides -n when set)")
+	colsValue := flag.Int("cols", 0, "Number of columns (overrides -n when set)")
+	useAll := flag.Bool("all", false, "Use every image, choosing a near-square grid automatically (ignores -n/-rows/-cols)")
+	layoutMode := flag.String("layout", "grid", "Layout: grid (uniform cells), pack (cells shaped by orientation) or justified (rows of uniform height aligned to the canvas width)")
+	pack := flag.Bool("pack", false, "Pack images by orientation: landscapes span two columns and portraits two rows (same as -layout pack)")
+	paginate := flag.Bool("paginate", false, "Use every image, writing one rows×cols page per file (out_1.png, out_2.png, ...)")
+	selectMode := flag.String("select", "random", "How to choose images: random, or sharpest (highest Laplacian-variance sharpness)")
+	first := flag.Bool("first", false, "Use the first images in listing order instead of a random sample")
+	repeat := flag.Bool("repeat", false, "Allow the same image to appear more than once so few images can fill a large grid")
+	pad := flag.Bool("pad", false, "Leave blank cells instead of failing when there are fewer images than cells")
+	contactSheet := flag.Bool("contact-sheet", false, "Contact sheet preset: small tiles, tight margins and captions with dimensions and file size")
+	canvasWidth := flag.Int("width", 0, "Fix the output canvas width in pixels and derive the tile size from it (overrides -tile)")
+	canvasHeight := flag.Int("height", 0, "Fix the output canvas height in pixels and derive the tile size from it (overrides -tile)")
 	tileSize := flag.Int("tile", 300, "Tile size (width/height in pixels for the cell)")
+	filter := flag.String("filter", "none", "Color filter: none, grayscale or sepia")
+	filterArea := flag.String("filter-area", "all", "Where -filter applies: tiles (images only, captions keep their color) or all (the whole canvas)")
+	brightness := flag.Float64("brightness", 0, "Linear brightness adjustment of each tile after resizing (-1.0 to 1.0)")
+	contrast := flag.Float64("contrast", 0, "Linear contrast adjustment of each tile after resizing (-1.0 to 1.0)")
+	grayscale := flag.String("grayscale", "none", "Shorthand for -filter grayscale with -filter-area: none, tiles or all")
+	flip := flag.String("flip", "none", "Mirror tiles: none, horizontal, vertical or alternate (every other tile horizontally)")
+	fillOrder := flag.String("fill-order", "row", "Order images fill grid cells: row (left to right, then down) or column (top to bottom, then right)")
+	aspect := flag.String("aspect", "", "Target canvas aspect ratio as W:H, e.g. 4:3; picks the rows and columns that fit the images closest to it (grid layout only)")
+	tileAspect := flag.String("tile-aspect", "", "Cell aspect ratio as W:H, e.g. 16:9 (the longer side is -tile; default square, grid layout only)")
+	margin := flag.Int("margin", 10, "Spacing between tiles and around the edge in pixels")
+	textHeight := flag.Int("textheight", 0, "Height of the caption area below each tile in pixels (0 derives it from the font)")
+	dpi := flag.Int("dpi", 0, "Resolution in dots per inch to record in PNG/JPEG metadata for print (0 leaves it unset)")
+	embedSources := flag.Bool("embed-sources", false, "Embed the list of source file paths in the PNG/JPEG metadata")
+	stream := flag.Bool("stream", false, "Render the canvas in horizontal bands while encoding to reduce peak memory for very large PNG/JPEG/TIFF output")
+	timeout := flag.Duration("timeout", 0, "Abort scanning and loading images after this long, e.g. 30s or 2m (0 waits indefinitely)")
+	workers := flag.Int("workers", runtime.NumCPU(), "Number of concurrent image decoders")
+	downloads := flag.Int("downloads", 4, "Maximum number of concurrent downloads for http(s) URLs in -list")
+	maxDimension := flag.Int("max-dimension", 0, "Downscale source images larger than this many pixels on a side right after decoding (0 disables)")
+	cacheDir := flag.String("cache-dir", "", "Directory for caching resized tiles between runs (disabled when empty)")
+	placeholder := flag.Bool("placeholder", false, "Draw a gray placeholder with the file name in place of images that fail to load")
+	skipErrors := flag.Bool("skip-errors", true, "Skip images that fail to load and report them instead of aborting (-skip-errors=false aborts on the first failure)")
+	dryRun := flag.Bool("dry-run", false, "Print the selected image paths and exit without rendering")
+	jsonOut := flag.Bool("json", false, "Print the result (output path, canvas size, tile count) as JSON instead of a message")
+	manifest := flag.String("manifest", "", "Write a sidecar .json or .csv listing each cell's row, column, pixel rectangle and source path")
+	legend := flag.Bool("legend", false, "Draw a numbered list of the file names below the grid instead of per-tile captions (unless -label is given)")
+	number := flag.Bool("number", false, "Draw each tile's placement number (1..n) in its top-left corner and print a number-to-file legend")
+	verbose := flag.Bool("verbose", false, "Log each image's source size, resized size, scale factor and placement")
+	quiet := flag.Bool("quiet", false, "Suppress the progress indicator")
+	seed := flag.Int64("seed", 0, "Random seed for image selection (0 uses the current time)")
+	dedupe := flag.Bool("dedupe", false, "Drop duplicate images before selection")
+	dedupeMode := flag.String("dedupe-mode", "sha256", "Duplicate detection for -dedupe: sha256 (identical files) or phash (visually similar)")
+	weight := flag.String("weight", "uniform", "Selection weighting: uniform or recency (newer files are more likely)")
+	noSort := flag.Bool("no-sort", false, "Keep selection order for placement (same as -sort none; reproducible with -seed)")
+	sortMode := flag.String("sort", "name", "Tile order: name, mtime, mtime-desc, color (by average hue), or random/none (keep selection order)")
+	fontPath := flag.String("font", "", "Path to a .ttf/.otf font for captions (default: built-in Inconsolata)")
+	fontStyle := flag.String("font-style", "regular", "Caption style of the Go font: regular, bold, italic or bold-italic (implies the Go font)")
+	titleStyle := flag.String("title-style", "regular", "Title style of the Go font: regular, bold, italic or bold-italic")
+	fallbackFont := flag.String("fallback-font", "", "Path to a .ttf/.otf/.ttc font for characters missing from the caption font, e.g. Japanese (default: a system CJK font when needed)")
+	hintingMode := flag.String("hinting", "full", "Glyph hinting for TrueType/OpenType fonts: full, vertical or none (none keeps subpixel glyph positions)")
+	fontSize := flag.Float64("fontsize", 0, "Caption font size in points using the Go font (0 uses the built-in Inconsolata)")
+	fit := flag.String("fit", "contain", "How images fill a tile: contain (letterbox) or cover (crop to fill)")
+	caption := flag.String("caption", "filename", "Caption source: filename or exif-date (EXIF DateTimeOriginal, falling back to the modification time)")
+	captions := flag.String("captions", "", "CSV file mapping filenames to captions (filename,caption per line)")
+	labelPos := flag.String("label-pos", "below", "Caption position: below, above or overlay")
+	trim := flag.Bool("trim", false, "Crop near-uniform borders (matching the top-left corner color) from every image before resizing, e.g. scan margins")
+	square := flag.Bool("square", false, "Center-crop every image to its largest square before resizing")
+	noUpscale := flag.Bool("no-upscale", false, "Draw images smaller than the tile at their native size instead of enlarging them")
+	interp := flag.String("interp", "lanczos3", "Resize interpolation: nearest, bilinear, bicubic, lanczos2 or lanczos3")
+	label := flag.String("label", "full", "Caption style: full, noext or none")
+	labelBg := flag.String("label-bg", "", "Caption background color as hex, filled behind each caption line (default: none; replaces the overlay strip)")
+	textColor := flag.String("text-color", "#000000", "Caption and title color as hex")
+	title := flag.String("title", "", "Title rendered above the grid")
+	radius := flag.Int("radius", 0, "Round the corners of each image with this radius in pixels (0 disables)")
+	border := flag.Int("border", 0, "Border width in pixels drawn around each image (0 disables)")
+	borderColor := flag.String("border-color", "#000000", "Border color as hex")
+	gridColor := flag.String("grid-color", "", "Draw continuous separator lines through the margins between cells in this hex color (grid layout only)")
+	shadow := flag.Bool("shadow", false, "Draw a soft drop shadow behind each image")
+	shadowTransparent := flag.Bool("shadow-transparent", false, "Draw shadows even when the background is transparent")
+	shuffle := flag.Bool("shuffle", false, "Shuffle tile placement after selection (reproducible with -seed)")
+	bgValue := flag.String("bg", "#ffffff", "Background color as hex (e.g. \"#202020\"), \"transparent\", or \"auto\" (average color of the images)")
+	bgImage := flag.String("bg-image", "", "Image drawn behind the grid, scaled to cover the whole canvas")
+	watermark := flag.String("watermark", "", "PNG image composited over the finished collage as a watermark")
+	watermarkPos := flag.String("watermark-pos", "br", "Watermark position: center, tl, tr, bl or br")
+	watermarkOpacity := flag.Float64("watermark-opacity", 0.5, "Watermark opacity (0-1)")
+	format := flag.String("format", "", "Output format: png, jpeg, webp, tiff or svg (default: from the -out extension)")
+	quality := flag.Int("quality", 90, "Output quality for jpg/webp (1-100)")
+	animate := flag.Bool("animate", false, "Write an animated GIF showing one image per frame instead of a grid (requires -out *.gif)")
+	delay := flag.Int("delay", 100, "Frame delay for -animate in 1/100 seconds")
+	pngCompression := flag.String("png-compression", "default", "Compression level for png output: default, speed, best or none")
+	tiffCompression := flag.String("tiff-compression", "deflate", "Compression for tif/tiff output: none or deflate")
+	configPath := flag.String("config", "", "JSON file whose keys are flag names and values their settings; flags given on the command line override it")
 	flag.Parse()
+	if *configPath != "" {
+		if err := applyConfigFile(*configPath); err != nil {
+			log.Fatalf("Failed to load config: %v", err)
+		}
+	}
 
-	if *dir == "" {
-		log.Fatal("Please specify a directory with -dir")
+	// -contact-sheet は明示的に指定されていないレイアウト設定を密なプリセットにする
+	if *contactSheet {
+		if !isFlagSet("tile") {
+			*tileSize = 150
+		}
+		if !isFlagSet("margin") {
+			*margin = 4
+		}
 	}
 
-	// 画像ファイル一覧取得
-	images, err := getImageFiles(*dir)
-	if err != nil {
+	sources := 0
+	for _, set := range []bool{*dir != "", *globPattern != "", *fromStdin, *zipPath != "", *listPath != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources == 0 {
+		log.Fatal("Please specify a directory with -dir, a pattern with -glob, -stdin, -zip or -list")
+	}
+	if sources > 1 {
+		log.Fatal("-dir, -glob, -stdin, -zip and -list cannot be used together")
+	}
+	switch *selectMode {
+	case "random":
+	case "sharpest":
+		if *first || *repeat || *weight != "uniform" || *listPath != "" || *zipPath != "" {
+			log.Fatal("-select sharpest cannot be used with -first, -repeat, -weight, -list or -zip")
+		}
+	default:
+		log.Fatalf("Invalid -select %q: must be random or sharpest", *selectMode)
+	}
+	if *first && (*repeat || *weight != "uniform" || *listPath != "") {
+		log.Fatal("-first cannot be used with -repeat, -weight or -list")
+	}
+	if *repeat && (*useAll || *paginate || *listPath != "" || *weight != "uniform") {
+		log.Fatal("-repeat cannot be used with -all, -paginate, -list or -weight")
+	}
+	if *noSort {
+		if isFlagSet("sort") && *sortMode != "none" && *sortMode != "random" {
+			log.Fatalf("-no-sort cannot be combined with -sort %s", *sortMode)
+		}
+		*sortMode = "none"
+	}
+	// -list は一覧の画像をそのままの順序で使うため、選択と並べ替えを行わない
+	if *listPath != "" {
+		if *dedupe || *weight != "uniform" {
+			log.Fatal("-list cannot be used with -dedupe or -weight")
+		}
+		if isFlagSet("sort") {
+			log.Fatal("-list cannot be combined with -sort: images keep the list order")
+		}
+		*sortMode = "random"
+	}
+	// zip内のエントリはファイルシステム上のパスではないため、ファイルを直接調べる機能は使えない
+	if *zipPath != "" {
+		if *dedupe || *weight == "recency" || *cacheDir != "" || strings.HasPrefix(*sortMode, "mtime") {
+			log.Fatal("-zip cannot be used with -dedupe, -weight recency, -cache-dir or -sort mtime")
+		}
+	}
+	if *downloads < 1 {
+		log.Fatalf("Invalid -downloads %d: must be at least 1", *downloads)
+	}
+	// -timeout はファイル一覧の取得と画像の読み込みを打ち切る（ネットワークマウントで止まらないように）
+	ctx := context.Background()
+	if *timeout < 0 {
+		log.Fatalf("Invalid -timeout %s: must not be negative", *timeout)
+	}
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	fatal := func(err error) {
+		if errors.Is(err, context.DeadlineExceeded) {
+			log.Fatalf("Timed out after %s: %v", *timeout, err)
+		}
 		log.Fatal(err)
 	}
+	scanOpts := collage.DefaultScanOptions()
+	scanOpts.Context = ctx
+	scanOpts.Recursive = *recursive
+	scanOpts.SkipHidden = *skipHidden
+	scanOpts.Limit = *limit
+	for _, pattern := range strings.Split(*exclude, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			log.Fatalf("Invalid -exclude pattern %q: %v", pattern, err)
+		}
+		scanOpts.Exclude = append(scanOpts.Exclude, pattern)
+	}
+	if *minSize != "" {
+		size, err := collage.ParseSize(*minSize)
+		if err != nil {
+			log.Fatalf("Invalid -min-size: %v", err)
+		}
+		scanOpts.MinSize = size
+	}
+	if *maxSize != "" {
+		size, err := collage.ParseSize(*maxSize)
+		if err != nil {
+			log.Fatalf("Invalid -max-size: %v", err)
+		}
+		scanOpts.MaxSize = size
+	}
+	if scanOpts.MaxSize > 0 && scanOpts.MinSize > scanOpts.MaxSize {
+		log.Fatalf("Invalid size range: -min-size %s is larger than -max-size %s", *minSize, *maxSize)
+	}
+	if *minWidth < 0 || *minHeight < 0 {
+		log.Fatalf("Invalid -min-width %d / -min-height %d: must be non-negative", *minWidth, *minHeight)
+	}
+	scanOpts.MinWidth = *minWidth
+	scanOpts.MinHeight = *minHeight
+	if *limit < 0 {
+		log.Fatalf("Invalid -limit %d: must be non-negative", *limit)
+	}
+	if *margin < 0 {
+		log.Fatalf("Invalid -margin %d: must be non-negative", *margin)
+	}
+	if *textHeight < 0 {
+		log.Fatalf("Invalid -textheight %d: must be non-negative", *textHeight)
+	}
+	if *border < 0 {
+		log.Fatalf("Invalid -border %d: must be non-negative", *border)
+	}
+	switch *layoutMode {
+	case "grid", "pack", "justified":
+	default:
+		log.Fatalf("Invalid -layout %q: must be grid, pack or justified", *layoutMode)
+	}
+	if *pack && *layoutMode != "grid" && *layoutMode != "pack" {
+		log.Fatalf("-pack cannot be combined with -layout %s", *layoutMode)
+	}
+	if *layoutMode == "justified" && *canvasHeight > 0 {
+		log.Fatal("-layout justified cannot be combined with -height")
+	}
+	if *legend && (*canvasHeight > 0 || *animate) {
+		log.Fatal("-legend cannot be combined with -height or -animate")
+	}
+	if *canvasWidth < 0 || *canvasHeight < 0 {
+		log.Fatalf("Invalid canvas size %dx%d: -width and -height must be non-negative", *canvasWidth, *canvasHeight)
+	}
+	if (*canvasWidth > 0 || *canvasHeight > 0) && (*animate || *cacheDir != "") {
+		log.Fatal("-width/-height cannot be combined with -animate or -cache-dir")
+	}
+	// キャッシュのタイルはリサイズ済みで元の縦横比が分からないため、縦横比で詰める配置とは併用できない
+	if (*pack || *layoutMode == "pack") && *cacheDir != "" {
+		log.Fatal("-pack cannot be combined with -cache-dir")
+	}
+	if *layoutMode == "justified" && *cacheDir != "" {
+		log.Fatal("-layout justified cannot be combined with -cache-dir")
+	}
+	if *radius < 0 {
+		log.Fatalf("Invalid -radius %d: must be non-negative", *radius)
+	}
+	if *maxDimension < 0 {
+		log.Fatalf("Invalid -max-dimension %d: must be non-negative", *maxDimension)
+	}
+	if *workers < 1 {
+		log.Fatalf("Invalid -workers %d: must be at least 1", *workers)
+	}
+	// -out-template は出力ごとに展開する。形式は展開前の拡張子で判定するため -out の代わりに使う
+	if *outTemplate != "" {
+		if isFlagSet("out") {
+			log.Fatal("-out-template cannot be used with -out")
+		}
+		if _, err := expandOutTemplate(*outTemplate, outTemplateVars(1, 0, 0)); err != nil {
+			log.Fatalf("Invalid -out-template: %v", err)
+		}
+		if *paginate && !strings.Contains(*outTemplate, "{index}") {
+			log.Fatal("-out-template must contain {index} with -paginate")
+		}
+		*output = *outTemplate
+	}
+	if *paginate && (*useAll || *animate || *output == "-") {
+		log.Fatal("-paginate cannot be combined with -all, -animate or -out -")
+	}
+	if *manifest != "" {
+		switch strings.ToLower(filepath.Ext(*manifest)) {
+		case ".json", ".csv":
+		default:
+			log.Fatalf("Invalid -manifest %q: must end in .json or .csv", *manifest)
+		}
+		if *animate {
+			log.Fatal("-manifest cannot be combined with -animate")
+		}
+	}
+	if *animate && *output != "-" && strings.ToLower(filepath.Ext(*output)) != ".gif" {
+		log.Fatal("-animate requires a .gif output file")
+	}
+	if *delay < 0 {
+		log.Fatalf("Invalid -delay %d: must be non-negative", *delay)
+	}
+	switch *watermarkPos {
+	case "center", "tl", "tr", "bl", "br":
+	default:
+		log.Fatalf("Invalid -watermark-pos %q: must be center, tl, tr, bl or br", *watermarkPos)
+	}
+	if *watermarkOpacity < 0 || *watermarkOpacity > 1 {
+		log.Fatalf("Invalid -watermark-opacity %v: must be between 0 and 1", *watermarkOpacity)
+	}
+	if *quality < 1 || *quality > 100 {
+		log.Fatalf("Invalid -quality %d: must be between 1 and 100", *quality)
+	}
 
-	total := (*nValue) * (*nValue)
-	if len(images) < total {
-		log.Fatalf("Not enough images in the directory: need at least %d, got %d", total, len(images))
+	switch *tiffCompression {
+	case "none", "deflate":
+	case "lzw":
+		log.Fatal("Invalid -tiff-compression \"lzw\": LZW encoding is not supported, use none or deflate")
+	default:
+		log.Fatalf("Invalid -tiff-compression %q: must be none or deflate", *tiffCompression)
 	}
 
-	// ランダムシード設定
-	rand.Seed(time.Now().UnixNano())
+	switch *pngCompression {
+	case "default", "speed", "best", "none":
+	default:
+		log.Fatalf("Invalid -png-compression %q: must be default, speed, best or none", *pngCompression)
+	}
 
-	// n×n枚ランダム選択
-	selected := randomSelect(images, total)
+	switch *dedupeMode {
+	case "sha256", "phash":
+	default:
+		log.Fatalf("Invalid -dedupe-mode %q: must be sha256 or phash", *dedupeMode)
+	}
 
-	// ここでファイル名でソート
-	sort.Strings(selected)
+	switch *weight {
+	case "uniform", "recency":
+	default:
+		log.Fatalf("Invalid -weight %q: must be uniform or recency", *weight)
+	}
 
-	// 画像読み込み
-	imgList, names := loadImages(selected)
+	switch *sortMode {
+	case "name", "mtime", "mtime-desc", "color", "random", "none":
+	default:
+		log.Fatalf("Invalid -sort %q: must be name, mtime, mtime-desc, color, random or none", *sortMode)
+	}
 
-	// コラージュ画像生成（アスペクト比維持）
-	collageImg := createCollageImage(imgList, names, *nValue, *tileSize)
+	switch *fit {
+	case "contain", "cover":
+	default:
+		log.Fatalf("Invalid -fit %q: must be contain or cover", *fit)
+	}
+	hinting, err := collage.ParseHinting(*hintingMode)
+	if err != nil {
+		log.Fatalf("Invalid -hinting %q: must be full, vertical or none", *hintingMode)
+	}
+	for name, style := range map[string]string{"font-style": *fontStyle, "title-style": *titleStyle} {
+		switch style {
+		case "regular", "bold", "italic", "bold-italic":
+		default:
+			log.Fatalf("Invalid -%s %q: must be regular, bold, italic or bold-italic", name, style)
+		}
+	}
+	switch *caption {
+	case "filename", "exif-date":
+	default:
+		log.Fatalf("Invalid -caption %q: must be filename or exif-date", *caption)
+	}
+	if *contactSheet && (*caption != "filename" || *captions != "") {
+		log.Fatal("-contact-sheet cannot be combined with -caption or -captions")
+	}
+	if *caption == "exif-date" && *captions != "" {
+		log.Fatal("-caption exif-date and -captions cannot be used together")
+	}
+	switch *labelPos {
+	case "below", "above", "overlay":
+	default:
+		log.Fatalf("Invalid -label-pos %q: must be below, above or overlay", *labelPos)
+	}
+	switch *interp {
+	case "nearest", "bilinear", "bicubic", "lanczos2", "lanczos3":
+	default:
+		log.Fatalf("Invalid -interp %q: must be nearest, bilinear, bicubic, lanczos2 or lanczos3", *interp)
+	}
+	switch *label {
+	case "full", "noext", "none":
+	default:
+		log.Fatalf("Invalid -label %q: must be full, noext or none", *label)
+	}
 
-	// 出力ファイルに書き込み
-	if err := saveImage(*output, collageImg); err != nil {
-		log.Fatalf("Failed to save image: %v", err)
+	// autoの背景色は画像を読み込んでから決める
+	autoBg := *bgValue == "auto"
+	bg := color.RGBA{A: 0xff}
+	if !autoBg {
+		if bg, err = collage.ParseColor(*bgValue); err != nil {
+			log.Fatalf("Invalid -bg: %v", err)
+		}
+	}
+	textRGBA, err := collage.ParseColor(*textColor)
+	if err != nil {
+		log.Fatalf("Invalid -text-color: %v", err)
+	}
+	var labelBgRGBA color.RGBA
+	if *labelBg != "" {
+		if labelBgRGBA, err = collage.ParseColor(*labelBg); err != nil {
+			log.Fatalf("Invalid -label-bg: %v", err)
+		}
+	}
+	borderRGBA, err := collage.ParseColor(*borderColor)
+	if err != nil {
+		log.Fatalf("Invalid -border-color: %v", err)
+	}
+	// 標準出力を画像やパス一覧、JSONに使う場合、メッセージは標準エラーへ
+	msgOut := io.Writer(os.Stdout)
+	if *output == "-" || *dryRun || *jsonOut {
+		msgOut = os.Stderr
+	}
+	// 結果の出力先（画像を標準出力に書く場合は標準エラー）
+	resultOut := io.Writer(os.Stdout)
+	if *output == "-" {
+		resultOut = os.Stderr
+	}
+	if *output == "-" && *format == "" && !*animate {
+		log.Fatal("-out - requires -format to choose the encoding")
+	}
+
+	// 出力形式（-format が拡張子と食い違う場合は -format を優先）
+	outFormat := collage.FormatFromExt(*output)
+	switch *format {
+	case "":
+	case "png", "jpeg", "webp", "tiff", "svg":
+		if outFormat != "" && outFormat != *format {
+			log.Printf("Warning: -format %s overrides the %s extension of %s", *format, filepath.Ext(*output), *output)
+		}
+		outFormat = *format
+	default:
+		log.Fatalf("Invalid -format %q: must be png, jpeg, webp, tiff or svg", *format)
+	}
+
+	if *dpi < 0 || *dpi > 65535 {
+		log.Fatalf("Invalid -dpi %d: must be between 0 and 65535", *dpi)
+	}
+	if *dpi > 0 && outFormat != "png" && outFormat != "jpeg" {
+		log.Printf("Warning: -dpi is only recorded in png and jpeg output")
 	}
-	fmt.Printf("Saved collage image to %s\n", *output)
-}
 
-// getImageFiles はディレクトリ内の画像ファイル一覧を取得
-func getImageFiles(dir string) ([]string, error) {
-	var files []string
-	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+	// JPEGはアルファを持たないため透過指定時は白で塗る
+	if bg.A == 0 && outFormat == "jpeg" {
+		bg = color.RGBA{255, 255, 255, 255}
+	}
+
+	cfg := collage.DefaultConfig()
+	cfg.N = *nValue
+	cfg.Rows = *rowsValue
+	cfg.Cols = *colsValue
+	cfg.Layout = *layoutMode
+	if *pack {
+		cfg.Layout = "pack"
+	}
+	switch *fillOrder {
+	case "row":
+	case "column":
+		if *pack || *layoutMode != "grid" {
+			log.Fatal("-fill-order column requires the grid layout")
+		}
+	default:
+		log.Fatalf("Invalid -fill-order %q: must be row or column", *fillOrder)
+	}
+	cfg.FillOrder = *fillOrder
+	cfg.TileSize = *tileSize
+	if *tileAspect != "" {
+		if *pack || *layoutMode != "grid" {
+			log.Fatal("-tile-aspect requires the grid layout")
+		}
+		if cfg.TileAspect, err = collage.ParseAspect(*tileAspect); err != nil {
+			log.Fatalf("Invalid -tile-aspect: %v", err)
+		}
+	}
+	var targetAspect float64
+	if *aspect != "" {
+		if *pack || *layoutMode != "grid" {
+			log.Fatal("-aspect requires the grid layout")
+		}
+		if *rowsValue > 0 || *colsValue > 0 {
+			log.Fatal("-aspect cannot be used with -rows or -cols")
+		}
+		if targetAspect, err = collage.ParseAspect(*aspect); err != nil {
+			log.Fatalf("Invalid -aspect: %v", err)
+		}
+	}
+	cfg.Width = *canvasWidth
+	cfg.Height = *canvasHeight
+	cfg.Margin = *margin
+	cfg.TextHeight = *textHeight
+	cfg.Fit = *fit
+	cfg.NoUpscale = *noUpscale
+	cfg.Square = *square
+	cfg.Trim = *trim
+	switch *flip {
+	case "none", "horizontal", "vertical", "alternate":
+	default:
+		log.Fatalf("Invalid -flip %q: must be none, horizontal, vertical or alternate", *flip)
+	}
+	cfg.Flip = *flip
+	// -grayscale は -filter grayscale と -filter-area をまとめて指定する短縮形
+	switch *grayscale {
+	case "none":
+	case "tiles", "all":
+		if isFlagSet("filter") || isFlagSet("filter-area") {
+			log.Fatal("-grayscale cannot be used with -filter or -filter-area")
+		}
+		*filter, *filterArea = "grayscale", *grayscale
+	default:
+		log.Fatalf("Invalid -grayscale %q: must be none, tiles or all", *grayscale)
+	}
+	switch *filter {
+	case "none", "grayscale", "sepia":
+	default:
+		log.Fatalf("Invalid -filter %q: must be none, grayscale or sepia", *filter)
+	}
+	switch *filterArea {
+	case "tiles", "all":
+	default:
+		log.Fatalf("Invalid -filter-area %q: must be tiles or all", *filterArea)
+	}
+	if *filter != "none" && *filterArea == "all" && outFormat == "svg" {
+		log.Fatal("Filtering the whole canvas is not supported for svg output; use -filter-area tiles")
+	}
+	for name, v := range map[string]float64{"brightness": *brightness, "contrast": *contrast} {
+		if v < -1 || v > 1 {
+			log.Fatalf("Invalid -%s %v: must be between -1.0 and 1.0", name, v)
+		}
+	}
+	cfg.Filter = *filter
+	cfg.FilterArea = *filterArea
+	cfg.Brightness = *brightness
+	cfg.Contrast = *contrast
+	cfg.Interp = *interp
+	// -legend はファイル名をタイルごとではなく凡例にまとめる（-label を指定した場合はタイルにも付ける）
+	if *legend && !isFlagSet("label") {
+		*label = "none"
+	}
+	cfg.Label = *label
+	cfg.LabelPos = *labelPos
+	if *captions != "" {
+		if cfg.Captions, err = collage.LoadCaptions(*captions); err != nil {
+			log.Fatalf("Failed to load captions: %v", err)
+		}
+	}
+	// Inconsolataには太字・斜体がないため、スタイル指定時はGoフォントを使う
+	styled := *fontStyle != "regular"
+	if styled && *fontPath != "" {
+		log.Fatal("-font-style cannot be used with -font")
+	}
+	if *fontPath != "" || *fontSize > 0 || styled {
+		size := *fontSize
+		if size <= 0 {
+			size = 16
+		}
+		var face font.Face
+		if *fontPath != "" {
+			face, err = collage.LoadFontFace(*fontPath, size, hinting)
+		} else {
+			face, err = collage.GoFontStyleFace(*fontStyle, size, hinting)
+		}
 		if err != nil {
-			return err
+			log.Fatalf("Failed to load font: %v", err)
 		}
-		if !d.IsDir() && isImageFile(path) {
-			files = append(files, path)
+		cfg.Font = face
+		// SVGはフォント名で指定するため、ラスター版と同じフォントの名前とスタイルを渡す
+		cfg.FontFamily, cfg.FontStyle = "Go", *fontStyle
+		if *fontPath != "" {
+			if cfg.FontFamily, err = collage.FontFamily(*fontPath); err != nil {
+				log.Fatalf("Failed to load font: %v", err)
+			}
+			cfg.FontStyle = ""
 		}
-		return nil
-	})
-	return files, err
-}
+	}
+	// 内蔵のInconsolataはビットマップフォントのためヒンティングの指定は効かない
+	if *hintingMode != "full" && !(*fontPath != "" || *fontSize > 0 || styled || *title != "" || *fallbackFont != "") {
+		log.Print("Warning: -hinting has no effect on the built-in Inconsolata; use -font or -fontsize")
+	}
+	cfg.TextColor = textRGBA
+	cfg.LabelBackground = labelBgRGBA
+	cfg.Title = *title
+	cfg.TitleStyle = *titleStyle
+	cfg.Hinting = *hintingMode
+	cfg.BorderWidth = *border
+	cfg.Radius = *radius
+	cfg.BorderColor = borderRGBA
+	if *gridColor != "" {
+		if *pack || *layoutMode != "grid" {
+			log.Fatal("-grid-color requires the grid layout")
+		}
+		if cfg.GridColor, err = collage.ParseColor(*gridColor); err != nil {
+			log.Fatalf("Invalid -grid-color: %v", err)
+		}
+	}
+	cfg.Shadow = *shadow
+	cfg.ShadowOnTransparent = *shadowTransparent
+	cfg.Background = bg
+	if *bgImage != "" {
+		bg, err := collage.LoadImage(*bgImage)
+		if err != nil {
+			log.Fatalf("Failed to load background image %s: %v", *bgImage, err)
+		}
+		cfg.BackgroundImage = bg
+	}
+	if *watermark != "" {
+		mark, err := collage.LoadImage(*watermark)
+		if err != nil {
+			log.Fatalf("Failed to load watermark %s: %v", *watermark, err)
+		}
+		cfg.Watermark = mark
+		cfg.WatermarkPos = *watermarkPos
+		cfg.WatermarkOpacity = *watermarkOpacity
+	}
+	// 配置結果（-number の凡例と -manifest 用に1ページ分を記録）
+	var placed []collage.Placement
+	if *verbose || *number || *manifest != "" {
+		cfg.OnPlace = func(p collage.Placement) {
+			placed = append(placed, p)
+			if !*verbose {
+				return
+			}
+			// Squareなどで切り取った場合に備え、縦横で大きい方の倍率を報告する
+			scale := max(float64(p.Rect.Dx())/float64(max(1, p.Source.X)), float64(p.Rect.Dy())/float64(max(1, p.Source.Y)))
+			note := ""
+			if scale > 1 {
+				note = " (upscaled)"
+			}
+			log.Printf("%s: %dx%d -> %dx%d (x%.3f) at (%d,%d)%s", p.Name, p.Source.X, p.Source.Y,
+				p.Rect.Dx(), p.Rect.Dy(), scale, p.Rect.Min.X, p.Rect.Min.Y, note)
+		}
+	}
+	// 凡例の番号は配置順のため、タイルの番号チップは -number を指定した場合だけ描画する
+	cfg.Number = *number
+	cfg.Legend = *legend
+	cfg.Format = *format
+	cfg.Quality = *quality
+	cfg.TIFFCompression = *tiffCompression
+	cfg.PNGCompression = *pngCompression
+	cfg.DPI = *dpi
+	// 出力ファイルに生成元を記録する
+	cfg.Software = "image-summarizer"
 
-// isImageFile は対応拡張子か判定
-func isImageFile(filename string) bool {
-	ext := strings.ToLower(filepath.Ext(filename))
-	for _, e := range supportedExt {
-		if ext == e {
-			return true
+	// 画像ファイル一覧取得
+	var images []string
+	source := *dir
+	loadImages := collage.LoadImagesWithInfo
+	switch {
+	case *zipPath != "":
+		source = *zipPath
+		archive, err := collage.OpenZip(*zipPath)
+		if err != nil {
+			log.Fatalf("Failed to open zip archive: %v", err)
 		}
+		defer archive.Close()
+		images = archive.ImageFiles(scanOpts)
+		loadImages = archive.LoadImages
+	case *globPattern != "":
+		source = *globPattern
+		images, err = collage.GlobImageFiles(*globPattern)
+		images = collage.FilterImageFiles(images, scanOpts)
+	case *listPath != "":
+		source = *listPath
+		images, err = collage.ReadImageListFile(*listPath)
+	case *fromStdin:
+		source = "stdin"
+		images, err = collage.ReadImageList(os.Stdin)
+		images = collage.FilterImageFiles(images, scanOpts)
+	default:
+		images, err = collage.GetImageFiles(*dir, scanOpts)
+	}
+	if err != nil {
+		fatal(err)
+	}
+	if *dedupe {
+		// 読み込めない画像は -skip-errors / -placeholder の場合は残し、読み込み時に報告する
+		var onError func(string, error)
+		if *skipErrors || *placeholder {
+			onError = func(string, error) {}
+		}
+		var removed int
+		if images, removed, err = collage.Dedupe(images, *dedupeMode, onError); err != nil {
+			log.Fatalf("Failed to dedupe images: %v", err)
+		}
+		fmt.Fprintf(msgOut, "Removed %d duplicate images\n", removed)
+	}
+	if len(images) == 0 {
+		log.Fatalf("No supported images found in %s (looked for %s)", source, strings.Join(collage.SupportedExt, ","))
 	}
-	return false
-}
 
-// randomSelect は与えられたスライスからランダムにn要素選ぶ
-func randomSelect(files []string, n int) []string {
-	perm := rand.Perm(len(files))
-	selected := make([]string, 0, n)
-	for i := 0; i < n; i++ {
-		selected = append(selected, files[perm[i]])
+	// グリッドの行数・列数（-all 指定時は全画像が収まるよう自動決定、
+	// それ以外は -rows/-cols が指定されていれば -n より優先）
+	// -list もページ分割しない場合は一覧の全画像が収まるグリッドにする
+	// -aspect は同じ枚数を収めるグリッドのうちキャンバスが目標の縦横比に最も近いものを選ぶ
+	// （-all / -list では全画像、それ以外は -n×-n 枚を収める。余ったセルは空白）
+	useList := *listPath != ""
+	count := 0
+	if *useAll || (useList && !*paginate) {
+		cfg.Rows, cfg.Cols = collage.GridFor(len(images))
+		count = len(images)
+	}
+	if targetAspect > 0 {
+		if count == 0 {
+			count = cfg.N * cfg.N
+		}
+		cfg.Rows, cfg.Cols = collage.GridForAspect(count, targetAspect, cfg)
+	}
+	rows, cols := cfg.Grid()
+
+	total := rows * cols
+	if targetAspect > 0 {
+		total = count
+	}
+	if *useAll || useList || *paginate || (*pad && !*repeat && len(images) < total) {
+		total = len(images)
+	}
+	if len(images) < total && !*repeat {
+		log.Fatalf("Not enough images in the directory: need at least %d, got %d", total, len(images))
 	}
-	return selected
-}
 
-// loadImages は画像を読み込む（リサイズは後で行うためここではそのまま）
-func loadImages(paths []string) ([]image.Image, []string) {
-	var imgList []image.Image
-	var names []string
-	for _, imgPath := range paths {
-		img, err := loadImage(imgPath)
+	// ランダムシード設定（-seed 未指定時は現在時刻）
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(*seed))
+	fmt.Fprintf(msgOut, "Using seed %d\n", *seed)
+
+	// -select sharpest は補充で選び直しても同じ画像を測り直さない
+	var sharpness *collage.SharpnessSelector
+	if *selectMode == "sharpest" {
+		sharpness = collage.NewSharpnessSelector()
+	}
+
+	// poolからn枚ランダム選択（-weight recency は新しいファイルほど選ばれやすい、-first は一覧の先頭から順に選ぶ）
+	pick := func(pool []string, n int) []string {
+		if sharpness != nil {
+			// 鮮明さを測れない画像は選ばない（読み込みでのエラーは後段で扱う）
+			opts := collage.LoadOptions{Workers: *workers, MaxDimension: *maxDimension, Context: ctx,
+				OnError: func(string, error) {}}
+			if !*quiet {
+				opts.OnProgress = progressPrinter("Scoring")
+			}
+			picked, err := sharpness.Select(pool, n, opts)
+			if err != nil {
+				fatal(err)
+			}
+			return picked
+		}
+		if *first {
+			return pool[:min(n, len(pool))]
+		}
+		if *repeat {
+			return collage.RepeatSelect(rng, pool, n)
+		}
+		if *weight != "recency" {
+			return collage.RandomSelect(rng, pool, n)
+		}
+		weights, err := collage.RecencyWeights(pool)
+		if err != nil {
+			log.Fatalf("Failed to stat images: %v", err)
+		}
+		picked, err := collage.WeightedSelect(rng, pool, weights, n)
 		if err != nil {
-			log.Fatalf("Failed to load image %s: %v", imgPath, err)
+			log.Fatalf("Failed to select images: %v", err)
 		}
-		imgList = append(imgList, img)
-		names = append(names, filepath.Base(imgPath))
+		return picked
+	}
+	selected := images
+	if !useList {
+		selected = pick(images, total)
 	}
-	return imgList, names
-}
 
-// loadImage はファイルから画像を読み込む
-func loadImage(path string) (image.Image, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, err
+	// -sort に従って並べ替え（randomは選択順のまま、colorは読み込み後に並べ替えるためここでは名前順）
+	fileSort := *sortMode
+	if fileSort == "color" {
+		fileSort = "name"
+	}
+	if err := collage.SortFiles(selected, fileSort); err != nil {
+		log.Fatalf("Failed to sort images: %v", err)
 	}
-	defer f.Close()
 
-	img, _, err := image.Decode(f)
+	// -dry-run は選択結果を表示して終了
+	if *dryRun {
+		for _, path := range selected {
+			fmt.Println(path)
+		}
+		return
+	}
+
+	// 画像読み込み
+	loadOpts := collage.LoadOptions{Workers: *workers, MaxDimension: *maxDimension, Context: ctx, MaxDownloads: *downloads}
+	skipped, placeheld := 0, 0
+	if *placeholder {
+		// 読み込めなかった画像は代替画像になるため、補充は行わない
+		loadOpts.Placeholder = true
+		loadOpts.OnError = func(path string, err error) {
+			placeheld++
+			log.Printf("Using a placeholder for %s: %v", path, err)
+		}
+	} else if *skipErrors {
+		// OnErrorは排他的に呼ばれるためそのまま数えられる
+		loadOpts.OnError = func(path string, err error) {
+			skipped++
+			log.Printf("Skipping %s: %v", path, err)
+		}
+	}
+	if *cacheDir != "" {
+		if loadOpts.Cache, err = collage.NewTileCache(*cacheDir, cfg); err != nil {
+			log.Fatalf("Failed to open cache: %v", err)
+		}
+	}
+	if !*quiet {
+		loadOpts.OnProgress = progressPrinter("Loading")
+	}
+	imgList, infos, err := loadImages(selected, loadOpts)
 	if err != nil {
-		return nil, err
+		fatal(err)
 	}
-	return img, nil
-}
 
-// createCollageImage はアスペクト比維持でリサイズ・配置、文字描画
-func createCollageImage(imgList []image.Image, names []string, n, tileSize int) image.Image {
-	margin := 10
-	textHeight := 20
+	// 読み込めなかった分を残りの候補から補充する（揃うか候補がなくなるまで繰り返す）
+	attempted := len(selected)
+	if skipped > 0 && len(imgList) < total {
+		chosen := make(map[string]bool, len(images))
+		for _, path := range selected {
+			chosen[path] = true
+		}
+		for len(imgList) < total {
+			var pool []string
+			for _, path := range images {
+				if !chosen[path] {
+					pool = append(pool, path)
+				}
+			}
+			if len(pool) == 0 {
+				break
+			}
+			extra := pick(pool, total-len(imgList))
+			for _, path := range extra {
+				chosen[path] = true
+			}
+			attempted += len(extra)
+			moreImgs, moreInfos, err := loadImages(extra, loadOpts)
+			if err != nil {
+				fatal(err)
+			}
+			imgList = append(imgList, moreImgs...)
+			infos = append(infos, moreInfos...)
+		}
+		imgList, infos = sortLoaded(imgList, infos, fileSort)
+	}
+	// -sort color は平均色の色相順に並べる
+	if *sortMode == "color" {
+		sortedImgs := make([]image.Image, len(imgList))
+		sortedInfos := make([]collage.ImageInfo, len(infos))
+		for i, j := range collage.HueOrder(imgList) {
+			sortedImgs[i], sortedInfos[i] = imgList[j], infos[j]
+		}
+		imgList, infos = sortedImgs, sortedInfos
+	}
+	if autoBg {
+		cfg.Background = collage.AverageColor(imgList)
+	}
+	if skipped > 0 {
+		fmt.Fprintf(msgOut, "Skipped %d of %d files that could not be loaded\n", skipped, attempted)
+	}
+	if placeheld > 0 {
+		fmt.Fprintf(msgOut, "Used placeholders for %d of %d files that could not be loaded\n", placeheld, attempted)
+	}
+	if len(imgList) == 0 {
+		log.Fatal("None of the selected images could be loaded")
+	}
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = info.Name
+	}
 
-	finalWidth := n*tileSize + (n+1)*margin
-	finalHeight := n*(tileSize+textHeight) + (n+1)*margin
+	// コンタクトシートはファイル名・寸法・ファイルサイズをキャプションにする
+	if *contactSheet {
+		cfg.Captions = make(map[string]string, len(infos))
+		for _, info := range infos {
+			cfg.Captions[info.Name] = collage.ContactSheetLabel(info)
+		}
+	}
 
-	outputImg := image.NewRGBA(image.Rect(0, 0, finalWidth, finalHeight))
+	// 撮影日時（なければ更新日時）をキャプションにする
+	if *caption == "exif-date" {
+		cfg.Captions = make(map[string]string, len(infos))
+		for _, info := range infos {
+			t := info.Taken
+			if t.IsZero() {
+				t = info.ModTime
+			}
+			cfg.Captions[info.Name] = t.Format("2006-01-02 15:04")
+		}
+	}
 
-	// 背景を白で塗りつぶし
-	draw.Draw(outputImg, outputImg.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	// ASCII以外の文字を含むラベル・タイトルは欧文フォントでは描画できないため、代替フォントを用意する
+	// -fontを指定した場合もタイトルはGoフォントのため、タイトルは常に判定する
+	labelled := names
+	if *fontPath != "" || (*label == "none" && !*legend) {
+		labelled = nil
+	}
+	fallbackPath := *fallbackFont
+	if fallbackPath == "" && needsFallback(labelled, cfg.Captions, cfg.Title) {
+		if fallbackPath = collage.FindFallbackFont(); fallbackPath == "" {
+			log.Print("Captions or the title contain non-ASCII characters but no CJK font was found; use -fallback-font to render them")
+		}
+	}
+	if fallbackPath != "" {
+		size := *fontSize
+		if size <= 0 {
+			size = 16
+		}
+		if cfg.FallbackFont, err = collage.LoadFontFace(fallbackPath, size, hinting); err != nil {
+			log.Fatalf("Failed to load fallback font: %v", err)
+		}
+		// タイトルは大きめのフォントのため、同じ大きさの代替フォントを別に読み込む
+		if cfg.Title != "" {
+			if cfg.TitleFallbackFont, err = collage.LoadFontFace(fallbackPath, collage.DefaultTitleSize, hinting); err != nil {
+				log.Fatalf("Failed to load fallback font: %v", err)
+			}
+		}
+	}
 
-	for i, originalImg := range imgList {
-		row := i / n
-		col := i % n
+	// 選択はそのままに配置だけをシャッフル（マニフェストや埋め込むパスも同じ順序にする）
+	if *shuffle {
+		collage.ShuffleImages(rng, imgList, names, infos)
+	}
 
-		// タイルの左上座標 (この中に画像を納める)
-		x := margin + col*(tileSize+margin)
-		y := margin + row*(tileSize+textHeight+margin)
+	// アニメーションGIF生成（1フレーム1画像）
+	if *animate {
+		anim, err := collage.CreateAnimation(imgList, cfg, *delay)
+		if err != nil {
+			log.Fatalf("Failed to create animation: %v", err)
+		}
+		out := *output
+		if *outTemplate != "" {
+			out, _ = expandOutTemplate(*outTemplate, outTemplateVars(1, len(anim.Image), *seed))
+		}
+		if err := collage.SaveAnimation(out, anim); err != nil {
+			log.Fatalf("Failed to save image: %v", err)
+		}
+		b := anim.Image[0].Bounds()
+		res := result{Output: out, Width: b.Dx(), Height: b.Dy(), Tiles: len(anim.Image)}
+		printResult(resultOut, "Saved animation to", res, *jsonOut)
+		return
+	}
+
+	// -paginate はrows×cols枚ずつのページに分けて出力（最後のページの余ったセルは空白）
+	perPage := len(imgList)
+	if *paginate {
+		perPage = rows * cols
+	}
+	for start, page := 0, 1; start < len(imgList); start, page = start+perPage, page+1 {
+		end := min(start+perPage, len(imgList))
+		placed = placed[:0]
+		// infosはimgListと同じ順序（-shuffle後も）のため、このページに描く画像のパスになる
+		if *embedSources {
+			cfg.Sources = make([]string, 0, end-start)
+			for _, info := range infos[start:end] {
+				cfg.Sources = append(cfg.Sources, info.Path)
+			}
+		}
+		out := *output
+		switch {
+		case *outTemplate != "":
+			out, _ = expandOutTemplate(*outTemplate, outTemplateVars(page, end-start, *seed))
+		case *paginate:
+			out = pageFilename(*output, page)
+		}
 
-		// オリジナル画像サイズ
-		ow := originalImg.Bounds().Dx()
-		oh := originalImg.Bounds().Dy()
+		// SVGは配置から直接書き出す
+		if outFormat == "svg" {
+			b, err := collage.SaveSVG(out, imgList[start:end], names[start:end], cfg)
+			if err != nil {
+				log.Fatalf("Failed to save image: %v", err)
+			}
+			res := result{Output: out, Width: b.Dx(), Height: b.Dy(), Tiles: end - start}
+			printResult(resultOut, "Saved collage SVG to", res, *jsonOut)
+			printLegend(msgOut, placed, *number)
+			writePageManifest(*manifest, page, *paginate, placed, infos[start:end])
+			continue
+		}
 
-		// アスペクト比維持リサイズ計算
-		var newW, newH uint
-		if float64(ow)/float64(oh) > 1.0 {
-			// 横長
-			newW = uint(tileSize)
-			newH = uint(float64(tileSize) * float64(oh) / float64(ow))
-		} else {
-			// 縦長または正方形
-			newH = uint(tileSize)
-			newW = uint(float64(tileSize) * float64(ow) / float64(oh))
+		// コラージュ画像生成（アスペクト比維持）
+		create := collage.Create
+		if *stream {
+			create = collage.CreateStream
+		}
+		collageImg, err := create(imgList[start:end], names[start:end], cfg)
+		if err != nil {
+			log.Fatalf("Failed to create collage: %v", err)
 		}
 
-		// リサイズ処理
-		resized := resize.Resize(newW, newH, originalImg, resize.Lanczos3)
+		// 出力ファイルに書き込み
+		if err := collage.Save(out, collageImg, cfg); err != nil {
+			log.Fatalf("Failed to save image: %v", err)
+		}
+		b := collageImg.Bounds()
+		res := result{Output: out, Width: b.Dx(), Height: b.Dy(), Tiles: end - start}
+		printResult(resultOut, "Saved collage image to", res, *jsonOut)
+		printLegend(msgOut, placed, *number)
+		writePageManifest(*manifest, page, *paginate, placed, infos[start:end])
+	}
+}
 
-		// 中央に配置
-		offsetX := x + (tileSize-int(newW))/2
-		offsetY := y + (tileSize-int(newH))/2
-		imgRect := image.Rect(offsetX, offsetY, offsetX+int(newW), offsetY+int(newH))
-		draw.Draw(outputImg, imgRect, resized, image.Point{}, draw.Over)
+// manifestEntry は -manifest に書き出す1セル分の情報
+type manifestEntry struct {
+	Number int    `json:"number"`
+	Row    int    `json:"row"`
+	Col    int    `json:"col"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Path   string `json:"path"`
+}
 
-		// ファイル名テキスト描画
-		drawText(outputImg, x, y+tileSize+5, names[i])
+// writePageManifest は1ページ分の配置をpath（-paginate 時はページ番号付き）に書き出す
+// 拡張子が .csv の場合はCSV、それ以外はJSONにする
+func writePageManifest(path string, page int, paginate bool, placed []collage.Placement, infos []collage.ImageInfo) {
+	if path == "" {
+		return
+	}
+	if paginate {
+		path = pageFilename(path, page)
+	}
+	entries := make([]manifestEntry, len(placed))
+	for i, p := range placed {
+		entries[i] = manifestEntry{
+			Number: p.Number,
+			Row:    p.Row,
+			Col:    p.Col,
+			X:      p.Rect.Min.X,
+			Y:      p.Rect.Min.Y,
+			Width:  p.Rect.Dx(),
+			Height: p.Rect.Dy(),
+			Path:   infos[p.Index].Path,
+		}
 	}
 
-	return outputImg
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatalf("Failed to write manifest: %v", err)
+	}
+	defer f.Close()
+	if strings.ToLower(filepath.Ext(path)) == ".csv" {
+		w := csv.NewWriter(f)
+		w.Write([]string{"number", "row", "col", "x", "y", "width", "height", "path"})
+		for _, e := range entries {
+			w.Write([]string{strconv.Itoa(e.Number), strconv.Itoa(e.Row), strconv.Itoa(e.Col),
+				strconv.Itoa(e.X), strconv.Itoa(e.Y), strconv.Itoa(e.Width), strconv.Itoa(e.Height), e.Path})
+		}
+		w.Flush()
+		err = w.Error()
+	} else {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(entries)
+	}
+	if err != nil {
+		log.Fatalf("Failed to write manifest: %v", err)
+	}
 }
 
-// drawText はイメージ上にテキストを描画する
-func drawText(img draw.Image, x, y int, text string) {
-	d := &font.Drawer{
-		Dst:  img,
-		Src:  image.NewUniform(textColor),
-		Face: textFont,
-		Dot: fixed.Point26_6{
-			X: fixed.I(x),
-			Y: fixed.I(y + textFont.Metrics().Ascent.Ceil()),
-		},
-	}
-	d.DrawString(text)
+// printLegend は -number で描画した番号と画像の対応を "1: name" 形式で出力する
+func printLegend(w io.Writer, placed []collage.Placement, enabled bool) {
+	if !enabled {
+		return
+	}
+	for _, p := range placed {
+		fmt.Fprintf(w, "%d: %s\n", p.Number, p.Name)
+	}
 }
 
-// saveImage は拡張子でPNG/JPEGを判定し保存する
-func saveImage(filename string, img image.Image) error {
-	f, err := os.Create(filename)
+// sortLoaded は読み込んだ画像とメタデータの組をパスに対して -sort の順に並べ直す
+func sortLoaded(imgList []image.Image, infos []collage.ImageInfo, mode string) ([]image.Image, []collage.ImageInfo) {
+	paths := make([]string, len(infos))
+	index := make(map[string]int, len(infos))
+	for i, info := range infos {
+		paths[i] = info.Path
+		index[info.Path] = i
+	}
+	if err := collage.SortFiles(paths, mode); err != nil {
+		log.Fatalf("Failed to sort images: %v", err)
+	}
+	sortedImgs := make([]image.Image, len(paths))
+	sortedInfos := make([]collage.ImageInfo, len(paths))
+	for i, path := range paths {
+		sortedImgs[i] = imgList[index[path]]
+		sortedInfos[i] = infos[index[path]]
+	}
+	return sortedImgs, sortedInfos
+}
+
+// pageFilename は出力ファイル名の拡張子の前にページ番号を挿入する（out.png → out_2.png）
+func pageFilename(path string, page int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s_%d%s", strings.TrimSuffix(path, ext), page, ext)
+}
+
+// outTemplateVars は -out-template の各プレースホルダーの値を返す（dateは実行した日付）
+func outTemplateVars(index, count int, seed int64) map[string]string {
+	return map[string]string{
+		"index": strconv.Itoa(index),
+		"date":  time.Now().Format("2006-01-02"),
+		"count": strconv.Itoa(count),
+		"seed":  strconv.FormatInt(seed, 10),
+	}
+}
+
+// expandOutTemplate はtmplの {name} をvarsの値に置き換える（未知の名前や閉じていない "{" はエラー）
+func expandOutTemplate(tmpl string, vars map[string]string) (string, error) {
+	var b strings.Builder
+	orig := tmpl
+	for {
+		open := strings.IndexByte(tmpl, '{')
+		if open < 0 {
+			b.WriteString(tmpl)
+			return b.String(), nil
+		}
+		end := strings.IndexByte(tmpl[open:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unclosed placeholder in %q", orig)
+		}
+		name := tmpl[open+1 : open+end]
+		value, ok := vars[name]
+		if !ok {
+			return "", fmt.Errorf("unknown placeholder {%s}: must be {index}, {date}, {count} or {seed}", name)
+		}
+		b.WriteString(tmpl[:open])
+		b.WriteString(value)
+		tmpl = tmpl[open+end+1:]
+	}
+}
+
+// result は生成結果の情報（-json の出力形式）
+type result struct {
+	Output string `json:"output"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Tiles  int    `json:"tiles"`
+}
+
+// printResult は生成結果をメッセージまたはJSONとしてwに出力する
+func printResult(w io.Writer, msg string, res result, asJSON bool) {
+	if !asJSON {
+		fmt.Fprintf(w, "%s %s (%dx%d, %d tiles)\n", msg, res.Output, res.Width, res.Height, res.Tiles)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Fatalf("Failed to write JSON: %v", err)
+	}
+}
+
+// progressPrinter は "Loading 42/900" 形式の進捗を標準エラーに出力する関数を返す
+// 標準エラーが端末の場合は同じ行を上書きする
+func progressPrinter(label string) func(done, total int) {
+	tty := false
+	if info, err := os.Stderr.Stat(); err == nil {
+		tty = info.Mode()&os.ModeCharDevice != 0
+	}
+	return func(done, total int) {
+		if !tty {
+			fmt.Fprintf(os.Stderr, "%s %d/%d\n", label, done, total)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "\r%s %d/%d", label, done, total)
+		if done == total {
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+}
+
+// applyConfigFile はJSONファイルのキーをフラグ名とみなし、コマンドラインで指定されていないフラグに値を設定する
+// 値は文字列・数値・真偽値のいずれかで、設定したフラグはコマンドラインで指定したものとして扱う
+func applyConfigFile(path string) error {
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	var values map[string]any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&values); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
 
-	ext := strings.ToLower(filepath.Ext(filename))
-	switch ext {
-	case ".png":
-		err = png.Encode(f, img)
-	case ".jpg", ".jpeg":
-		err = jpeg.Encode(f, img, &jpeg.Options{Quality: 90})
-	default:
-		return errors.New("unsupported output format")
+	// コマンドラインの指定を優先するため、設定する前に指定済みのフラグを記録する
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "config" || flag.Lookup(name) == nil {
+			return fmt.Errorf("%s: unknown flag %q", path, name)
+		}
+		if explicit[name] {
+			continue
+		}
+		var value string
+		switch v := values[name].(type) {
+		case string:
+			value = v
+		case json.Number:
+			value = v.String()
+		case bool:
+			value = strconv.FormatBool(v)
+		default:
+			return fmt.Errorf("%s: value of %q must be a string, number or boolean", path, name)
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("%s: invalid value %q for %q: %w", path, value, name, err)
+		}
 	}
-	return err
+	return nil
+}
+
+// isFlagSet はnameのフラグがコマンドラインか-configのファイルで明示的に指定されたか判定する
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// needsFallback はファイル名・キャプション・タイトルにASCII以外の文字が含まれるか判定する
+func needsFallback(names []string, captions map[string]string, title string) bool {
+	if collage.NeedsFallback(title) {
+		return true
+	}
+	for _, name := range names {
+		if caption, ok := captions[name]; ok {
+			name = caption
+		}
+		if collage.NeedsFallback(name) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMain はrunMainから子プロセスとして起動された場合にmainを実行する
+func TestMain(m *testing.M) {
+	if args, ok := os.LookupEnv("IMAGE_SUMMARIZER_ARGS"); ok {
+		os.Args = append([]string{"image-summarizer"}, strings.Split(args, "\n")...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// mainCommand はargsを渡してmainを実行する子プロセスとしてテストのバイナリを起動するコマンドを返す
+// （mainはフラグを登録してlog.Fatalで終了するため、同じプロセスでは繰り返し呼べない）
+func mainCommand(args ...string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = append(os.Environ(), "IMAGE_SUMMARIZER_ARGS="+strings.Join(args, "\n"))
+	return cmd
+}
+
+// runMain はargsでmainを実行し、失敗したらテストを止める
+func runMain(t *testing.T, args ...string) {
+	t.Helper()
+	if out, err := mainCommand(args...).CombinedOutput(); err != nil {
+		t.Fatalf("image-summarizer %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+}
+
+// writeSolidImages はdirに一色ずつ異なる色のPNGをn枚書き出し、パスと色の対応を返す
+func writeSolidImages(t *testing.T, dir string, n int) map[string]color.RGBA {
+	t.Helper()
+	colors := make(map[string]color.RGBA, n)
+	for i := 0; i < n; i++ {
+		c := color.RGBA{uint8(40 * i), uint8(255 - 40*i), uint8(20 * i), 255}
+		img := image.NewRGBA(image.Rect(0, 0, 32, 32))
+		for p := 0; p < len(img.Pix); p += 4 {
+			img.Pix[p], img.Pix[p+1], img.Pix[p+2], img.Pix[p+3] = c.R, c.G, c.B, c.A
+		}
+		path := filepath.Join(dir, fmt.Sprintf("img%d.png", i))
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := png.Encode(f, img); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		colors[path] = c
+	}
+	return colors
+}
+
+// readPNGFile はPNGファイルを読み込む
+func readPNGFile(t *testing.T, path string) image.Image {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestShuffleManifest(t *testing.T) {
+	dir := t.TempDir()
+	colors := writeSolidImages(t, dir, 4)
+	out := filepath.Join(t.TempDir(), "out.png")
+	manifest := filepath.Join(t.TempDir(), "manifest.json")
+	runMain(t, "-dir", dir, "-n", "2", "-seed", "3", "-shuffle", "-label", "none", "-quiet",
+		"-manifest", manifest, "-out", out)
+
+	data, err := os.ReadFile(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []manifestEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(colors) {
+		t.Fatalf("manifest has %d entries, want %d", len(entries), len(colors))
+	}
+	// 各セルの中央の色がマニフェストのパスの画像の色と一致する
+	img := readPNGFile(t, out)
+	for _, e := range entries {
+		want, ok := colors[e.Path]
+		if !ok {
+			t.Fatalf("manifest entry %d has unknown path %q", e.Number, e.Path)
+		}
+		got := color.RGBAModel.Convert(img.At(e.X+e.Width/2, e.Y+e.Height/2)).(color.RGBA)
+		if got != want {
+			t.Errorf("cell %d (row %d, col %d) is %v, but the manifest names %s (%v)", e.Number, e.Row, e.Col, got, filepath.Base(e.Path), want)
+		}
+	}
+}
+
+// pngDescription はPNGのiTXtチャンクのDescription（-embed-sourcesで埋め込んだパスの一覧）を返す
+func pngDescription(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for p := 8; p+8 <= len(data); {
+		n := int(binary.BigEndian.Uint32(data[p:]))
+		typ, body := string(data[p+4:p+8]), data[p+8:p+8+n]
+		// キーワード、圧縮なしのフラグ・方式、空の言語タグと翻訳したキーワードの後が本文
+		if prefix := []byte("Description\x00\x00\x00\x00\x00"); typ == "iTXt" && bytes.HasPrefix(body, prefix) {
+			return string(body[len(prefix):])
+		}
+		p += 12 + n
+	}
+	return ""
+}
+
+func TestShufflePaginateEmbedSources(t *testing.T) {
+	dir := t.TempDir()
+	colors := writeSolidImages(t, dir, 6)
+	out := filepath.Join(t.TempDir(), "out.png")
+	runMain(t, "-dir", dir, "-n", "2", "-paginate", "-seed", "3", "-shuffle", "-label", "none",
+		"-embed-sources", "-quiet", "-out", out)
+
+	for page := 1; page <= 2; page++ {
+		path := pageFilename(out, page)
+		// ページに描かれた色（タイルの色）と、埋め込まれたパスの画像の色が一致する
+		drawn := map[color.RGBA]bool{}
+		img := readPNGFile(t, path)
+		b := img.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA); c != (color.RGBA{255, 255, 255, 255}) {
+					drawn[c] = true
+				}
+			}
+		}
+		embedded := map[color.RGBA]bool{}
+		for _, src := range strings.Split(pngDescription(t, path), "\n") {
+			c, ok := colors[src]
+			if !ok {
+				t.Fatalf("page %d embeds unknown source %q", page, src)
+			}
+			embedded[c] = true
+		}
+		if !maps.Equal(drawn, embedded) {
+			t.Errorf("page %d draws %v but embeds the sources of %v", page, drawn, embedded)
+		}
+	}
+}
+
+func TestLegendWithoutTileCaptions(t *testing.T) {
+	dir := t.TempDir()
+	writeSolidImages(t, dir, 4)
+	out := t.TempDir()
+	render := func(name string, args ...string) []byte {
+		t.Helper()
+		path := filepath.Join(out, name)
+		runMain(t, append([]string{"-dir", dir, "-n", "2", "-seed", "1", "-legend", "-quiet", "-out", path}, args...)...)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	// -legend だけならタイルにラベルも番号も付けない
+	plain := render("legend.png")
+	if clean := render("clean.png", "-label", "none"); !bytes.Equal(plain, clean) {
+		t.Error("-legend differs from -legend -label none")
+	}
+	if labelled := render("labelled.png", "-label", "full"); bytes.Equal(plain, labelled) {
+		t.Error("-legend -label full draws no tile captions")
+	}
+	if numbered := render("numbered.png", "-number"); bytes.Equal(plain, numbered) {
+		t.Error("-legend -number draws no number chips")
+	}
+}
+
+func TestListRejectsSort(t *testing.T) {
+	dir := t.TempDir()
+	colors := writeSolidImages(t, dir, 2)
+	list := filepath.Join(dir, "list.txt")
+	var paths []string
+	for path := range colors {
+		paths = append(paths, path)
+	}
+	if err := os.WriteFile(list, []byte(strings.Join(paths, "\n")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := mainCommand("-list", list, "-sort", "name", "-quiet", "-out", filepath.Join(t.TempDir(), "out.png")).CombinedOutput()
+	if err == nil || !strings.Contains(string(out), "-list cannot be combined with -sort") {
+		t.Errorf("-list -sort name: err %v, output %s; want a -sort conflict error", err, out)
+	}
+}